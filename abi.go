@@ -1,13 +1,14 @@
 package lensvm
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"reflect"
+	"sync/atomic"
 
+	"github.com/lens-vm/lens-vm-go-host/engine"
 	"github.com/lens-vm/lens-vm-go-sdk/types"
-
-	"github.com/wasmerio/wasmer-go/wasmer"
 )
 
 var (
@@ -18,140 +19,304 @@ var (
 	ErrRegisterNotFunc      = errors.New("register a non-func object")
 	ErrRegisterArgNum       = errors.New("register func with invalid arg num")
 	ErrRegisterArgType      = errors.New("register func with invalid arg type")
+	ErrRegisterReserved     = errors.New("register func with a reserved name")
+	ErrNoAllocator          = errors.New("guest has no memory to allocate in")
 )
 
-// func (vm *VM) ABIImportObject() *wasmer.ImportObject {
-
-// }
-
-// func (vm *VM) newABIFunctions() error {
-
-// }
-
+// statusInternalFailure is the status returned by the host
+// ABI functions when they fail for any other reason than
+// their arguments, eg. allocating guest memory
+const statusInternalFailure = int32(types.StatusErrUnknown)
+
+// The exec calling convention
+//
+// Every lens function is exported by the guest in one of
+// lensvm_exec_<name>(forward, argsStart, argsSize, inputStart, inputSize) status
+// lensvm_exec_<name>(contextID, forward, argsPtr, argsSize, inputPtr, inputSize) status
+//
+// Before the call the host sets the input arg and input data
+// buffers. With the first form, the guest reads them with
+// lensvm_get_buffer using the given offsets and sizes, which
+// copies them into guest memory, see Module.allocate. With
+// the second form, the one the SDK generates, the host copies
+// them into guest memory itself, and frees them once the
+// lens returns, see Module.free.
+// Forward is 1 when the lens is applied, and 0 when it is
+// reverted.
+//
+// The guest writes a JSON Merge Patch of the input into the
+// output patch buffer with lensvm_set_buffer, and returns a
+// status. If the lens fails, it may write an error message
+// into the output patch buffer instead, see ExecError.
+//
+// A lens of an imported module is called through the import
+// of the same name and signature. With the first form, the
+// calling lens sets the temp input arg and temp input data
+// buffers, and with the second form, it passes pointers into
+// its own memory. Either way, it gets the patch of the called
+// lens in the temp input data buffer.
+
+// wasmPageSize is the size of a single page of linear memory
+const wasmPageSize = 65536
+
+// GetBuffer returns the current contents of the host
+// buffer of the given type.
 func (vm *VM) GetBuffer(bufferType types.BufferType) []byte {
-	return nil
+	return vm.buffers[bufferType]
 }
 
+// SetBuffer replaces the contents of the host buffer
+// of the given type with a copy of data.
 func (vm *VM) SetBuffer(bufferType types.BufferType, data []byte) error {
+	if !isValidBufferType(bufferType) {
+		return ErrInvalidParam
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	vm.buffers[bufferType] = buf
 	return nil
 }
 
-func (vm *VM) lensVMGetBufferBytes(bufferType, start, maxsize, retData, retSize int32) int32 {
-	return 0
+// resetBuffers releases the contents of all the host buffers
+func (vm *VM) resetBuffers() {
+	vm.buffers = make(map[types.BufferType][]byte)
 }
 
-func (vm *VM) lensVMSetBufferBytes(bufferType, start, maxsize, ptr, size int32) int32 {
-	return 0
+func isValidBufferType(bufferType types.BufferType) bool {
+	return bufferType <= types.BufferTypeTempInputArg
 }
 
-func (m *Module) RegisterFunc(namespace string, funcName string, f interface{}) error {
-
-	if namespace == "" || funcName == "" {
-		return ErrInvalidParam
+// lensVMGetBufferBytes is the lensvm_get_buffer host function. It copies
+// at most maxsize bytes of the host buffer, starting at the start offset,
+// into newly allocated guest memory, see Module.allocate. The address
+// and size of the copy are written to the retData and retSize pointers.
+func (m *Module) lensVMGetBufferBytes(c *CallContext, bufferType, start, maxsize, retData, retSize int32) int32 {
+	bt := types.BufferType(bufferType)
+	if !isValidBufferType(bt) {
+		return int32(types.StatusNotFound)
+	}
+	if start < 0 || maxsize < 0 {
+		return int32(types.StatusErrBadArgument)
 	}
 
-	if f == nil || reflect.ValueOf(f).IsNil() {
-		return ErrInvalidParam
+	buf := m.vm.buffers[bt]
+	if int(start) > len(buf) {
+		return int32(types.StatusErrBadArgument)
+	}
+	buf = buf[start:]
+	if int(maxsize) < len(buf) {
+		buf = buf[:maxsize]
 	}
 
-	if reflect.TypeOf(f).Kind() != reflect.Func {
-		return ErrRegisterNotFunc
+	addr, err := m.allocate(c.Context(), int32(len(buf)))
+	if err != nil {
+		m.vm.hostErr = err
+		return statusInternalFailure
+	}
+	if err := c.Write(addr, buf); err != nil {
+		return int32(types.StatusErrBadArgument)
+	}
+	if err := c.Write(retData, uint32Bytes(uint32(addr))); err != nil {
+		return int32(types.StatusErrBadArgument)
+	}
+	if err := c.Write(retSize, uint32Bytes(uint32(len(buf)))); err != nil {
+		return int32(types.StatusErrBadArgument)
 	}
+	return int32(types.StatusOK)
+}
 
-	funcType := reflect.TypeOf(f)
+// lensVMSetBufferBytes is the lensvm_set_buffer host function. It copies
+// size bytes from guest memory at ptr into the host buffer, starting at
+// the start offset, and truncates the buffer after the copied bytes.
+// If maxsize is non zero, at most maxsize bytes are copied.
+func (m *Module) lensVMSetBufferBytes(c *CallContext, bufferType, start, maxsize, ptr, size int32) int32 {
+	bt := types.BufferType(bufferType)
+	if !isValidBufferType(bt) {
+		return int32(types.StatusNotFound)
+	}
+	if start < 0 || maxsize < 0 || size < 0 {
+		return int32(types.StatusErrBadArgument)
+	}
+	if maxsize > 0 && size > maxsize {
+		size = maxsize
+	}
 
-	argsNum := funcType.NumIn()
-	if argsNum < 1 {
-		return ErrRegisterArgNum
+	data, err := c.Read(ptr, size)
+	if err != nil {
+		return int32(types.StatusErrBadArgument)
 	}
 
-	argsKind := make([]*wasmer.ValueType, argsNum)
-	for i := 0; i < argsNum; i++ {
-		argsKind[i] = convertFromGoType(funcType.In(i))
+	buf := m.vm.buffers[bt]
+	if int(start) > len(buf) {
+		return int32(types.StatusErrBadArgument)
 	}
+	m.vm.buffers[bt] = append(buf[:start:start], data...)
+	return int32(types.StatusOK)
+}
 
-	retsNum := funcType.NumOut()
-	retsKind := make([]*wasmer.ValueType, retsNum)
-	for i := 0; i < retsNum; i++ {
-		retsKind[i] = convertFromGoType(funcType.Out(i))
+// allocate reserves size bytes of guest memory for the host to write
+// into. If the guest exports a malloc function it is used, and the
+// guest owns the allocated memory. Otherwise the memory is taken from
+// a scratch region at the end of the linear memory, which is reset on
+// every exec call, and given up once the guest grows its memory.
+func (m *Module) allocate(ctx context.Context, size int32) (int32, error) {
+	if m.winst == nil {
+		return 0, ErrInstanceNotStart
+	}
+	if size < 1 {
+		size = 1
 	}
 
-	fwasmer := wasmer.NewFunction(
-		m.vm.wstore,
-		wasmer.NewFunctionType(argsKind, retsKind),
-		func(args []wasmer.Value) (callRes []wasmer.Value, err error) {
-			defer func() {
-				if r := recover(); r != nil {
-					callRes = nil
-					err = fmt.Errorf("panic [%v] when calling func [%v]", r, funcName)
-				}
-			}()
+	malloc, err := m.winst.Function("malloc")
+	if err != nil {
+		return m.allocateScratch(ctx, size)
+	}
+	res, err := malloc.Call(ctx, size)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != 1 {
+		return 0, fmt.Errorf("guest failed to allocate %d bytes", size)
+	}
+	addr, ok := res[0].(int32)
+	if !ok || addr == 0 {
+		return 0, fmt.Errorf("guest failed to allocate %d bytes", size)
+	}
+	return addr, nil
+}
 
-			aa := make([]reflect.Value, 1+len(args))
+// free releases the guest memory the host allocated at the given
+// addresses with the guest malloc, through the guest free function,
+// if the guest exports one. Scratch allocations are released on
+// every exec call instead.
+func (m *Module) free(ctx context.Context, addrs ...int32) error {
+	if m.winst == nil || len(addrs) == 0 {
+		return nil
+	}
+	if _, err := m.winst.Function("malloc"); err != nil {
+		return nil
+	}
+	free, err := m.winst.Function("free")
+	if err != nil {
+		return nil
+	}
 
-			for i, arg := range args {
-				aa[i] = convertToGoTypes(arg)
-			}
+	for _, addr := range addrs {
+		if _, err := free.Call(ctx, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			callResult := reflect.ValueOf(f).Call(aa)
+// allocateScratch allocates size bytes in the scratch region,
+// which is grown when it's full
+func (m *Module) allocateScratch(ctx context.Context, size int32) (int32, error) {
+	mem, ok := engine.Caller(ctx)
+	if !ok {
+		mem = m.wmem
+	}
+	if mem == nil {
+		return 0, ErrNoAllocator
+	}
 
-			var ret []wasmer.Value
-			if len(callResult) > 0 {
-				ret = []wasmer.Value{convertFromGoValue(callResult[0])}
-			}
+	// once the guest grows its memory, its allocator may take
+	// all of it up to the new end, eg. TinyGo does, including
+	// the region, so it's only used while it ends the memory
+	if int64(m.scratchBase)+int64(m.scratchSize) != int64(len(mem.Data())) {
+		m.scratchBase, m.scratchSize, m.scratchUsed = 0, 0, 0
+	}
+	if m.scratchUsed+size > m.scratchSize {
+		if err := m.growScratch(mem, size); err != nil {
+			return 0, err
+		}
+	}
+	addr := m.scratchBase + m.scratchUsed
+	m.scratchUsed += size
+	return addr, nil
+}
 
-			return ret, nil
-		},
-	)
+// growScratch grows the linear memory to make room for a new
+// scratch region of at least size bytes. The pages are taken
+// from the memory budget, like the pages the guest grows.
+func (m *Module) growScratch(mem engine.Memory, size int32) error {
+	pages := uint32((size + wasmPageSize - 1) / wasmPageSize)
+	if !m.vm.budget.reserve(pages) {
+		return fmt.Errorf("%w: failed to grow guest memory by %d pages", ErrMemoryLimit, pages)
+	}
 
-	m.importObject.Register(namespace, map[string]wasmer.IntoExtern{
-		funcName: fwasmer,
-	})
+	base := int32(len(mem.Data()))
+	if !mem.Grow(pages) {
+		m.vm.budget.release(pages)
+		return fmt.Errorf("%w: failed to grow guest memory by %d pages", ErrMemoryLimit, pages)
+	}
+	atomic.AddUint32(&m.pages, pages)
 
+	m.scratchBase = base
+	m.scratchSize = int32(pages) * wasmPageSize
+	m.scratchUsed = 0
 	return nil
 }
 
-func convertFromGoType(t reflect.Type) *wasmer.ValueType {
-	switch t.Kind() {
-	case reflect.Int32:
-		return wasmer.NewValueType(wasmer.I32)
-	case reflect.Int64:
-		return wasmer.NewValueType(wasmer.I64)
-	case reflect.Float32:
-		return wasmer.NewValueType(wasmer.F32)
-	case reflect.Float64:
-		return wasmer.NewValueType(wasmer.F64)
+// resetScratch releases all the host allocations made in
+// the scratch regions of the modules, once no guest call
+// uses them anymore.
+func (vm *VM) resetScratch() {
+	for _, mod := range vm.moduleImports {
+		mod.scratchUsed = 0
+	}
+}
+
+// readMemory returns a copy of size bytes of the
+// memory data at addr, or ErrAddrOverflow.
+func readMemory(data []byte, addr, size int32) ([]byte, error) {
+	if addr < 0 || size < 0 || int64(addr)+int64(size) > int64(len(data)) {
+		return nil, ErrAddrOverflow
 	}
 
-	return nil
+	buf := make([]byte, size)
+	copy(buf, data[addr:addr+size])
+	return buf, nil
 }
 
-func convertToGoTypes(in wasmer.Value) reflect.Value {
-	switch in.Kind() {
-	case wasmer.I32:
-		return reflect.ValueOf(in.I32())
-	case wasmer.I64:
-		return reflect.ValueOf(in.I64())
-	case wasmer.F32:
-		return reflect.ValueOf(in.F32())
-	case wasmer.F64:
-		return reflect.ValueOf(in.F64())
+// writeMemory copies buf into the memory
+// data at addr, or fails with ErrAddrOverflow.
+func writeMemory(data []byte, addr int32, buf []byte) error {
+	if addr < 0 || int64(addr)+int64(len(buf)) > int64(len(data)) {
+		return ErrAddrOverflow
 	}
 
-	return reflect.Value{}
+	copy(data[addr:], buf)
+	return nil
+}
+
+// copyToGuest copies the data into newly allocated
+// guest memory, and returns its address
+func (m *Module) copyToGuest(ctx context.Context, data []byte) (int32, error) {
+	addr, err := m.allocate(ctx, int32(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	if err := writeMemory(m.wmem.Data(), addr, data); err != nil {
+		return 0, err
+	}
+	return addr, nil
 }
 
-func convertFromGoValue(val reflect.Value) wasmer.Value {
-	switch val.Kind() {
-	case reflect.Int32:
-		return wasmer.NewI32(int32(val.Int()))
-	case reflect.Int64:
-		return wasmer.NewI64(int64(val.Int()))
-	case reflect.Float32:
-		return wasmer.NewF32(float32(val.Float()))
-	case reflect.Float64:
-		return wasmer.NewF64(float64(val.Float()))
+// sliceBuffer returns size bytes of the host
+// buffer at start, or ErrAddrOverflow
+func sliceBuffer(buf []byte, start, size int32) ([]byte, error) {
+	if start < 0 || size < 0 || int64(start)+int64(size) > int64(len(buf)) {
+		return nil, ErrAddrOverflow
 	}
+	return buf[start : start+size], nil
+}
 
-	return wasmer.Value{}
+// uint32Bytes encodes v in the byte
+// order of the guest memory
+func uint32Bytes(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return buf
 }
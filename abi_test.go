@@ -0,0 +1,226 @@
+package lensvm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+	"github.com/lens-vm/lens-vm-go-host/engine/wazero"
+	stypes "github.com/lens-vm/lens-vm-go-sdk/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVMSetGetBuffer(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	data := []byte(`{"body": "hello"}`)
+	err := vm.SetBuffer(stypes.BufferTypeInputData, data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, vm.GetBuffer(stypes.BufferTypeInputData))
+
+	// the buffer holds its own copy of the data
+	data[0] = '['
+	assert.Equal(t, byte('{'), vm.GetBuffer(stypes.BufferTypeInputData)[0])
+
+	assert.Empty(t, vm.GetBuffer(stypes.BufferTypeOutputPatch))
+}
+
+func TestVMSetBufferInvalidType(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeTempInputData, []byte("data")))
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeTempInputArg, []byte("args")))
+
+	err := vm.SetBuffer(stypes.BufferTypeTempInputArg+1, []byte("data"))
+	assert.Equal(t, ErrInvalidParam, err)
+}
+
+// instantiateModule instantiates the wasm module with the
+// host ABI functions of the VM, outside of any module graph
+func instantiateModule(t *testing.T, vm *VM, path string) *Module {
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	wmod, err := vm.engine.Compile(buf)
+	assert.NoError(t, err)
+
+	mod := &Module{vm: vm, id: path, wmod: wmod, imports: make(engine.Imports)}
+	assert.NoError(t, vm.registerHostFuncs(mod))
+	mod.winst, err = wmod.Instantiate(context.Background(), mod.imports, vm.wasi)
+	assert.NoError(t, err)
+	mod.wmem, err = mod.winst.Memory("memory")
+	assert.NoError(t, err)
+	return mod
+}
+
+func TestGetBufferBytes(t *testing.T) {
+	vm := NewVM(&Options{Engine: wazero.New()})
+	mod := instantiateModule(t, vm, "testdata/simple/main.wasm")
+	defer mod.winst.Close()
+	c := &CallContext{ctx: context.Background(), module: mod}
+
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeTempInputArg, []byte("hello world")))
+	status := mod.lensVMGetBufferBytes(c, int32(stypes.BufferTypeTempInputArg), 6, 100, 0, 4)
+	assert.Equal(t, int32(stypes.StatusOK), status)
+
+	ret, err := c.Read(0, 8)
+	assert.NoError(t, err)
+	addr := int32(binary.LittleEndian.Uint32(ret))
+	size := int32(binary.LittleEndian.Uint32(ret[4:]))
+	assert.NotZero(t, addr)
+	data, err := c.Read(addr, size)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	status = mod.lensVMSetBufferBytes(c, int32(stypes.BufferTypeOutputPatch), 0, 0, addr, size)
+	assert.Equal(t, int32(stypes.StatusOK), status)
+	assert.Equal(t, "world", string(vm.GetBuffer(stypes.BufferTypeOutputPatch)))
+
+	status = mod.lensVMGetBufferBytes(c, 5, 0, 0, 0, 4)
+	assert.Equal(t, int32(stypes.StatusNotFound), status)
+	status = mod.lensVMGetBufferBytes(c, 0, -1, 0, 0, 4)
+	assert.Equal(t, int32(stypes.StatusErrBadArgument), status)
+}
+
+func TestGetBufferBytesScratch(t *testing.T) {
+	vm := NewVM(&Options{Engine: wazero.New()})
+	mod := instantiateModule(t, vm, "testdata/loop/main.wasm")
+	defer mod.winst.Close()
+	c := &CallContext{ctx: context.Background(), module: mod}
+
+	// without a guest allocator, the host allocates
+	// in a scratch region at the end of the memory
+	_, err := mod.winst.Function("malloc")
+	assert.True(t, errors.Is(err, engine.ErrExportNotFound))
+	size := int32(len(mod.wmem.Data()))
+	addr, err := mod.allocate(context.Background(), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, size, addr)
+	addr, err = mod.allocate(context.Background(), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, size+4, addr)
+
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeInputData, []byte("data")))
+	status := mod.lensVMGetBufferBytes(c, int32(stypes.BufferTypeInputData), 0, 4, 0, 4)
+	assert.Equal(t, int32(stypes.StatusOK), status)
+	ret, err := c.Read(0, 4)
+	assert.NoError(t, err)
+	data, err := c.Read(int32(binary.LittleEndian.Uint32(ret)), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// the region is reused once it's reset
+	vm.moduleImports[mod.id] = mod
+	vm.resetScratch()
+	addr, err = mod.allocate(context.Background(), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, size, addr)
+}
+
+func TestGetBufferBytesScratchGuestGrow(t *testing.T) {
+	vm := NewVM(&Options{Engine: wazero.New()})
+	mod := instantiateModule(t, vm, "testdata/grow/main.wasm")
+	defer mod.winst.Close()
+
+	addr, err := mod.copyToGuest(context.Background(), []byte("host"))
+	require.NoError(t, err)
+
+	// the guest grows its memory, and its allocator takes
+	// all of it, including the scratch region
+	grow, err := mod.winst.Function("lensvm_exec_grow")
+	require.NoError(t, err)
+	params := make([]interface{}, len(grow.Params()))
+	for i := range params {
+		params[i] = int32(0)
+	}
+	_, err = grow.Call(context.Background(), params...)
+	require.NoError(t, err)
+	size := int32(len(mod.wmem.Data()))
+	guest := mod.wmem.Data()[addr:size]
+	for i := range guest {
+		guest[i] = 0xff
+	}
+
+	// the host allocates after the memory of the guest
+	addr, err = mod.copyToGuest(context.Background(), []byte("host"))
+	require.NoError(t, err)
+	assert.Equal(t, size, addr)
+	for _, b := range mod.wmem.Data()[size-int32(len(guest)) : size] {
+		require.Equal(t, byte(0xff), b)
+	}
+}
+
+func TestGetBufferBytesScratchBudget(t *testing.T) {
+	vm := NewVM(&Options{Engine: wazero.New()})
+	mod := instantiateModule(t, vm, "testdata/loop/main.wasm")
+	defer mod.winst.Close()
+	c := &CallContext{ctx: context.Background(), module: mod}
+
+	// the scratch region doesn't fit into the budget, and
+	// the host keeps the cause of the failure for the exec
+	vm.budget = newMemoryBudget(1)
+	vm.budget.reserve(1)
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeInputData, []byte("data")))
+	status := mod.lensVMGetBufferBytes(c, int32(stypes.BufferTypeInputData), 0, 4, 0, 4)
+	assert.Equal(t, int32(stypes.StatusErrUnknown), status)
+	assert.True(t, errors.Is(vm.hostErr, ErrMemoryLimit), vm.hostErr)
+}
+
+func TestExecWithoutMalloc(t *testing.T) {
+	for name, newEngine := range testEngines {
+		t.Run(name, func(t *testing.T) {
+			vm := NewVM(&Options{
+				Resolvers: DefaultOptions.Resolvers,
+				Engine:    newEngine(),
+			})
+			require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json")))
+			require.NoError(t, vm.Init())
+			defer vm.Close()
+
+			// the SDK built module doesn't export malloc
+			mod := vm.moduleImports["file://testdata/simple/module.json"]
+			_, err := mod.winst.Function("malloc")
+			assert.True(t, errors.Is(err, engine.ErrExportNotFound))
+
+			out, err := vm.Exec([]byte(`{"body": "hello"}`))
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"body": "hello", "description": "hello"}`, string(out))
+
+			out, err = vm.ExecFunc([]byte(`{"name": "lens"}`), []byte(`{"source": "name", "destination": "title"}`), "rename", "file://testdata/simple/module.json")
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"name": "lens", "title": "lens"}`, string(out))
+		})
+	}
+}
+
+func TestExecFreesGuestCopies(t *testing.T) {
+	for name, newEngine := range testEngines {
+		t.Run(name, func(t *testing.T) {
+			vm := NewVM(&Options{
+				Resolvers: DefaultOptions.Resolvers,
+				Engine:    newEngine(),
+			})
+			defer vm.Close()
+
+			// the args and input the host copies into guest
+			// memory are freed once the lens returns
+			for i := 0; i < 3; i++ {
+				out, err := vm.ExecFunc([]byte(`{"name": "lens"}`), []byte(`{}`), "echo", "file://testdata/malloc/module.json")
+				require.NoError(t, err)
+				assert.JSONEq(t, `{"name": "lens"}`, string(out))
+
+				live, err := vm.moduleImports["file://testdata/malloc/module.json"].winst.Global("live")
+				require.NoError(t, err)
+				n, err := live.Get()
+				require.NoError(t, err)
+				assert.Equal(t, int32(0), n)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Command lensvm inspects lens files with the lens VM.
+//
+// Usage:
+//
+//	lensvm graph [-format dot|json] [resolver flags] <lens file>
+//
+// The graph subcommand resolves the imports of the lens file, without
+// compiling their modules, and prints its dependancy graph in the Graphviz DOT language or as
+// a JSON document.
+//
+// The resolver flags select the resolvers of the lens imports:
+//
+//	-resolvers file,http,https,ipfs,npm,wapm
+//		the URI schemes to resolve, only file by default
+//	-ipfs-gateway url
+//		the gateway of the ipfs resolver
+//	-npm-registry url
+//		the registry of the npm resolver
+//	-wapm-registry url
+//		the registry of the wapm resolver
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	lensvm "github.com/lens-vm/lens-vm-go-host"
+	"github.com/lens-vm/lens-vm-go-host/resolvers"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/file"
+	httpresolver "github.com/lens-vm/lens-vm-go-host/resolvers/http"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/ipfs"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/npm"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/wapm"
+)
+
+const graphUsage = "lensvm graph [-format dot|json] [resolver flags] <lens file>"
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "lensvm:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("Missing subcommand, usage: " + graphUsage)
+	}
+
+	switch args[0] {
+	case "graph":
+		return graph(args[1:], stdout)
+	default:
+		return fmt.Errorf("Unknown subcommand %s", args[0])
+	}
+}
+
+func graph(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := flags.String("format", "dot", "output format, dot or json")
+	res := addResolverFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected a single lens file, usage: " + graphUsage)
+	}
+
+	path := flags.Arg(0)
+	if !strings.Contains(path, "://") {
+		path = "file://" + path
+	}
+
+	opts, err := res.options()
+	if err != nil {
+		return err
+	}
+	vm, err := lensvm.NewVMWithError(opts)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	if err := vm.ResolveLens(lensvm.LensFileLoader(path)); err != nil {
+		return err
+	}
+
+	g, err := vm.DependancyGraph()
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "dot":
+		return g.WriteDOT(stdout)
+	case "json":
+		buf, err := g.JSON()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, string(buf))
+		return err
+	default:
+		return fmt.Errorf("Unknown graph format %s", *format)
+	}
+}
+
+// resolverFlags are the flags selecting and
+// configuring the resolvers of the lens imports
+type resolverFlags struct {
+	schemes      *string
+	ipfsGateway  *string
+	npmRegistry  *string
+	wapmRegistry *string
+}
+
+func addResolverFlags(flags *flag.FlagSet) resolverFlags {
+	return resolverFlags{
+		schemes:      flags.String("resolvers", "file", "comma separated URI schemes to resolve: file, http, https, ipfs, npm and wapm"),
+		ipfsGateway:  flags.String("ipfs-gateway", ipfs.DefaultGatewayURL, "gateway of the ipfs resolver"),
+		npmRegistry:  flags.String("npm-registry", npm.DefaultRegistryURL, "registry of the npm resolver"),
+		wapmRegistry: flags.String("wapm-registry", wapm.DefaultRegistryURL, "registry of the wapm resolver"),
+	}
+}
+
+// options returns the VM options with the selected resolvers
+func (f resolverFlags) options() (*lensvm.Options, error) {
+	opts := &lensvm.Options{}
+	for _, scheme := range strings.Split(*f.schemes, ",") {
+		var r resolvers.Resolver
+		switch strings.TrimSpace(scheme) {
+		case "file":
+			r = file.FileResolver{}
+		case "http":
+			r = httpresolver.HTTPResolver{}
+		case "https":
+			r = httpresolver.HTTPResolver{Secure: true}
+		case "ipfs":
+			r = ipfs.IPFSResolver{Blocks: ipfs.Gateway{URL: *f.ipfsGateway}}
+		case "npm":
+			r = &npm.NPMResolver{Registry: *f.npmRegistry}
+		case "wapm":
+			r = &wapm.WAPMResolver{Registry: *f.wapmRegistry}
+		default:
+			return nil, fmt.Errorf("Unknown resolver %s", scheme)
+		}
+		opts.Resolvers = append(opts.Resolvers, r)
+	}
+	return opts, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newModuleServer serves the simple module over http,
+// with the given package, or its own if nil
+func newModuleServer(t *testing.T, wasm []byte) *httptest.Server {
+	modFile, err := ioutil.ReadFile("../../testdata/simple/module.json")
+	require.NoError(t, err)
+	if wasm == nil {
+		wasm, err = ioutil.ReadFile("../../testdata/simple/main.wasm")
+		require.NoError(t, err)
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	modFile = []byte(strings.Replace(string(modFile), "file://testdata/simple/main.wasm", srv.URL+"/main.wasm", 1))
+	mux.HandleFunc("/module.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(modFile)
+	})
+	mux.HandleFunc("/main.wasm", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(wasm)
+	})
+	return srv
+}
+
+// writeLens writes a lens file importing the module at
+// the given URI, and returns its path
+func writeLens(t *testing.T, uri string) string {
+	path := filepath.Join(t.TempDir(), "lens.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"import": {"rename": "`+uri+`/module.json"},
+		"lenses": [{"rename": {"source": "body", "destination": "description"}}]
+	}`), 0644)
+	require.NoError(t, err)
+	return path
+}
+
+func TestRunGraph(t *testing.T) {
+	srv := newModuleServer(t, nil)
+	defer srv.Close()
+	lens := writeLens(t, srv.URL)
+
+	var out bytes.Buffer
+	err := run([]string{"graph", "-resolvers", "file,http", "-format", "json", lens}, &out)
+	require.NoError(t, err)
+
+	var g map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &g))
+	assert.Contains(t, out.String(), srv.URL+"/module.json")
+	assert.Contains(t, out.String(), srv.URL+"/main.wasm")
+
+	out.Reset()
+	err = run([]string{"graph", "-resolvers", "file,http", lens}, &out)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.String(), "digraph"), out.String())
+
+	err = run([]string{"graph", "-resolvers", "file,http", "-format", "svg", lens}, &out)
+	assert.Error(t, err)
+
+	// without the http resolver, the import can't be resolved
+	err = run([]string{"graph", lens}, &out)
+	assert.Error(t, err)
+}
+
+func TestRunGraphResolveOnly(t *testing.T) {
+	// the graph is built without compiling the modules
+	srv := newModuleServer(t, []byte("not wasm"))
+	defer srv.Close()
+	lens := writeLens(t, srv.URL)
+
+	var out bytes.Buffer
+	err := run([]string{"graph", "-resolvers", "file,http", lens}, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), srv.URL+"/main.wasm")
+}
+
+func TestRunErrors(t *testing.T) {
+	var out bytes.Buffer
+	assert.Error(t, run(nil, &out))
+	assert.Error(t, run([]string{"unknown"}, &out))
+	assert.Error(t, run([]string{"graph"}, &out))
+	assert.Error(t, run([]string{"graph", "-resolvers", "file,ftp", "lens.json"}, &out))
+}
+
+func TestResolverFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	res := addResolverFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-resolvers", "file, http,https,ipfs,npm,wapm"}))
+
+	opts, err := res.options()
+	require.NoError(t, err)
+	var schemes []string
+	for _, r := range opts.Resolvers {
+		schemes = append(schemes, r.Scheme())
+	}
+	assert.Equal(t, []string{"file", "http", "https", "ipfs", "npm", "wapm"}, schemes)
+}
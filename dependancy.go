@@ -1,11 +1,36 @@
 package lensvm
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/lens-vm/gogl"
-	"github.com/lens-vm/gogl/dfs"
 	"github.com/lens-vm/gogl/graph/al"
 )
 
+var ErrDependancyCycle = errors.New("Dependancy cycle")
+
+// CycleError is returned when the imports of the
+// modules form a cycle, eg. copy -> rename -> copy
+type CycleError struct {
+	// Imports are the names of the imports along the
+	// cycle, the first and last name are the same
+	Imports []string
+	// Modules are the IDs of the imported modules along
+	// the cycle, the first and last ID are the same
+	Modules []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", ErrDependancyCycle, strings.Join(e.Imports, " -> "), strings.Join(e.Modules, " -> "))
+}
+
+func (e *CycleError) Is(target error) bool {
+	return target == ErrDependancyCycle
+}
+
 type dependancyNode struct{}
 
 type dependancyGraph struct {
@@ -48,8 +73,14 @@ func newDependancyGraph() *dependancyGraph {
 //                  └─> G
 
 func (vm *VM) makeDependancyGraph() error {
+	if err := vm.linkDependancies(); err != nil {
+		return err
+	}
+
 	vm.dgraph = newDependancyGraph()
 	for id, mod := range vm.moduleImports {
+		// modules without dependancies are vertices too
+		vm.dgraph.graph.EnsureVertex(id)
 		for lens, modDep := range mod.dependancies {
 			vm.dgraph.graph.AddEdges(gogl.NewLabeledEdge(id, modDep.id, lens))
 		}
@@ -57,17 +88,131 @@ func (vm *VM) makeDependancyGraph() error {
 	return nil
 }
 
-func (d dependancyGraph) SortOrder(root string) ([]string, error) {
-	var sortV []gogl.Vertex
-	sortV, err := dfs.Toposort(d.graph, root)
-	if err != nil {
-		return nil, err
+// linkDependancies links the imports of modules which were resolved
+// by another importer, so their imported module is empty, to the
+// module imported with the same ID.
+func (vm *VM) linkDependancies() error {
+	for _, mod := range vm.moduleImports {
+		for name, imp := range mod.definition.Imports {
+			if _, ok := mod.dependancies[name]; ok {
+				continue
+			}
+			dep, ok := vm.moduleImports[imp.Path]
+			if !ok {
+				return &LinkError{Module: mod.id, Import: name, Err: fmt.Errorf("%w: %s", ErrMissingDependancy, imp.Path)}
+			}
+			if !moduleHasLensFunc(dep.definition, name) {
+				return &LinkError{Module: mod.id, Import: name, Err: ErrMissingLensFunc}
+			}
+			// the graph has no loops, so a module
+			// importing itself is checked here
+			if dep == mod {
+				return &CycleError{
+					Imports: []string{name, name},
+					Modules: []string{mod.id, mod.id},
+				}
+			}
+			mod.setLensImport(name, dep)
+		}
 	}
+	return nil
+}
 
-	sortS := make([]string, len(sortV))
-	for i, v := range sortV {
-		sortS[i] = v.(string)
+// checkDependancyCycles checks the imports of all
+// the modules of the VM for cycles
+func (vm *VM) checkDependancyCycles() error {
+	if err := vm.makeDependancyGraph(); err != nil {
+		return err
 	}
 
-	return sortS, nil
+	_, err := vm.dgraph.SortOrder(sortedKeys(vm.moduleImports)...)
+	return err
+}
+
+// SortOrder returns the IDs of the given root modules and all of
+// their dependancies, with every module after its dependancies.
+// The roots, and the dependancies of every module, are visited in
+// order, so the sort order is deterministic. If the dependancies
+// form a cycle, it returns a *CycleError.
+func (d dependancyGraph) SortOrder(roots ...string) ([]string, error) {
+	var order []string
+	visited := make(map[string]bool)
+
+	// the current path of the search, and the
+	// imports the modules in the path are
+	// imported with
+	var path, imports []string
+	onPath := make(map[string]int)
+
+	var visit func(id, lens string) error
+	visit = func(id, lens string) error {
+		if visited[id] {
+			return nil
+		}
+		if i, ok := onPath[id]; ok {
+			cycle := &CycleError{
+				Imports: append([]string{lens}, imports[i+1:]...),
+				Modules: append([]string{}, path[i:]...),
+			}
+			cycle.Imports = append(cycle.Imports, lens)
+			cycle.Modules = append(cycle.Modules, id)
+			return cycle
+		}
+
+		onPath[id] = len(path)
+		path = append(path, id)
+		imports = append(imports, lens)
+		for _, dep := range d.dependancies(id) {
+			if err := visit(dep.id, dep.lens); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		imports = imports[:len(imports)-1]
+		delete(onPath, id)
+
+		visited[id] = true
+		order = append(order, id)
+		return nil
+	}
+
+	for _, root := range roots {
+		if !d.graph.HasVertex(root) {
+			return nil, fmt.Errorf("%w: %s", ErrMissingDependancy, root)
+		}
+		if err := visit(root, ""); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// dependancy is an import of a module, with
+// the ID of the imported module
+type dependancy struct {
+	id   string
+	lens string
+}
+
+// dependancies returns the imports of the given module, sorted
+// by module ID. The graph holds a single edge per imported module,
+// so only one of the lens functions it imports from a module is
+// returned, see Module.dependancies for all of them.
+func (d dependancyGraph) dependancies(id string) []dependancy {
+	var deps []dependancy
+	d.graph.ArcsFrom(id, func(a gogl.Arc) bool {
+		dep := dependancy{id: a.Target().(string)}
+		if l, ok := a.(gogl.LabeledArc); ok {
+			dep.lens = l.Label()
+		}
+		deps = append(deps, dep)
+		return false
+	})
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].id != deps[j].id {
+			return deps[i].id < deps[j].id
+		}
+		return deps[i].lens < deps[j].lens
+	})
+	return deps
 }
@@ -1,10 +1,18 @@
 package lensvm
 
 import (
-	"fmt"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/lens-vm/lens-vm-go-host/resolvers"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/cache"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/file"
+	httpresolver "github.com/lens-vm/lens-vm-go-host/resolvers/http"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +36,51 @@ func TestSimpleResolveModule(t *testing.T) {
 
 }
 
+func TestCachedResolveModule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lensvm-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.FileServer(http.Dir(".")).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	opts := &Options{
+		Resolvers:     []resolvers.Resolver{file.FileResolver{}, httpresolver.HTTPResolver{}},
+		ResolverCache: &cache.Options{Dir: dir},
+	}
+	uri := srv.URL + "/testdata/simple/module.json"
+	var vm *VM
+	for i := 0; i < 2; i++ {
+		vm = NewVM(opts)
+		assert.NotNil(t, vm)
+
+		mod, err := vm.ResolveModule(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, "rename", mod.Name)
+	}
+	assert.Equal(t, 1, requests)
+
+	// only the module file, the wasm package is
+	// a local file, which isn't cached
+	index, err := ioutil.ReadDir(filepath.Join(dir, "index"))
+	assert.NoError(t, err)
+	assert.Len(t, index, 1)
+
+	assert.NoError(t, vm.EvictResolverCache(uri))
+	assert.NoError(t, vm.PruneResolverCache())
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	assert.NoError(t, err)
+	assert.Len(t, blobs, 0)
+
+	_, err = vm.ResolveModule(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests)
+}
+
 func TestMultiResolveModule(t *testing.T) {
 	vm := NewVM(nil)
 	assert.NotNil(t, vm)
@@ -75,6 +128,31 @@ func TestImportDeepResolveModule(t *testing.T) {
 	assert.Equal(t, "file://testdata/simple/module.json", mod.Imports["extract"].Module.Imports["rename"].Path)
 }
 
+func TestIntegrityResolveModule(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	mod, err := vm.ResolveModule("file://testdata/integrity/module.json")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "extract", mod.Name)
+	assert.Equal(t, "file://testdata/simple/main.wasm", mod.PackagePath)
+	assert.Equal(t, "rename", mod.Imports["rename"].Module.Name)
+	assert.NotEmpty(t, mod.Imports["rename"].Integrity)
+}
+
+func TestIntegrityMismatchResolveModule(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	_, err := vm.ResolveModule("file://testdata/integrity/tampered.json")
+	assert.True(t, errors.Is(err, ErrIntegrityMismatch))
+
+	var ierr *IntegrityError
+	assert.True(t, errors.As(err, &ierr))
+	assert.Equal(t, "file://testdata/simple/main.wasm", ierr.URI)
+}
+
 func TestSimpleImportFunction(t *testing.T) {
 	vm := NewVM(nil)
 	assert.NotNil(t, vm)
@@ -124,7 +202,6 @@ func TestDeepLens(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Len(t, vm.moduleImports, 3)
-	fmt.Println(vm.lensImports)
 	assert.Len(t, vm.lensImports, 1)
 }
 
@@ -141,7 +218,7 @@ func TestGraphSortSimple(t *testing.T) {
 	var i string
 	// grab the first import path
 	for _, v := range vm.lensFile.Import {
-		i = v
+		i = v.URI
 		break
 	}
 	deps, err := vm.dgraph.SortOrder(i)
@@ -164,7 +241,7 @@ func TestGraphSortDeep(t *testing.T) {
 	var i string
 	// grab the first import path
 	for _, v := range vm.lensFile.Import {
-		i = v
+		i = v.URI
 		break
 	}
 	deps, err := vm.dgraph.SortOrder(i)
@@ -178,6 +255,96 @@ func TestGraphSortDeep(t *testing.T) {
 		deps)
 }
 
+func TestGraphSortMultiRoot(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/multiroot/lens.json"))
+	assert.NoError(t, err)
+	assert.Len(t, vm.moduleImports, 3)
+	assert.Len(t, vm.lensImports, 3)
+
+	err = vm.makeDependancyGraph()
+	assert.NoError(t, err)
+
+	deps, err := vm.dgraph.SortOrder(
+		"file://testdata/importsimple/module.json",
+		"file://testdata/multi/module.json",
+		"file://testdata/simple/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"file://testdata/simple/module.json",
+		"file://testdata/importsimple/module.json",
+		"file://testdata/multi/module.json"},
+		deps)
+}
+
+func TestGraphSortMissingRoot(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.makeDependancyGraph()
+	assert.NoError(t, err)
+
+	_, err = vm.dgraph.SortOrder("file://testdata/simple/module.json")
+	assert.True(t, errors.Is(err, ErrMissingDependancy))
+}
+
+func TestLinkDependancies(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+
+	// rename is resolved by the copy module, so the
+	// rename import of the extract module is empty,
+	// and linked when the graph is made
+	extract := vm.moduleImports["file://testdata/importsimple/module.json"]
+	assert.Equal(t, vm.moduleImports["file://testdata/simple/module.json"], extract.dependancies["rename"])
+}
+
+func TestDependancyCycle(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/cycle/lens.json"))
+	assert.True(t, errors.Is(err, ErrDependancyCycle))
+
+	var cycleErr *CycleError
+	if assert.True(t, errors.As(err, &cycleErr)) {
+		assert.Equal(t, []string{"copy", "rename", "copy"}, cycleErr.Imports)
+		assert.Equal(t, []string{
+			"file://testdata/cycle/a/module.json",
+			"file://testdata/cycle/b/module.json",
+			"file://testdata/cycle/a/module.json"},
+			cycleErr.Modules)
+	}
+	assert.Equal(t, "Dependancy cycle: copy -> rename -> copy "+
+		"(file://testdata/cycle/a/module.json -> file://testdata/cycle/b/module.json -> file://testdata/cycle/a/module.json)",
+		err.Error())
+}
+
+func TestDependancyCycleSelf(t *testing.T) {
+	vm := NewVM(nil)
+	_, err := vm.ImportModule("file://testdata/cycle/self/module.json")
+	assert.NoError(t, err)
+
+	err = vm.checkDependancyCycles()
+	var cycleErr *CycleError
+	if assert.True(t, errors.As(err, &cycleErr)) {
+		assert.Equal(t, []string{"rename", "rename"}, cycleErr.Imports)
+	}
+}
+
+func TestExecFuncMissingLens(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	_, err := vm.ExecFunc([]byte(`{"body": "hello"}`), []byte(`{}`), "missing", "file://testdata/simple/module.json")
+	assert.True(t, errors.Is(err, ErrMissingLensFunc), err)
+
+	var linkErr *LinkError
+	if assert.True(t, errors.As(err, &linkErr)) {
+		assert.Equal(t, "file://testdata/simple/module.json", linkErr.Module)
+		assert.Equal(t, "missing", linkErr.Import)
+	}
+}
+
 /*
 vm := lensvm.NewVM(nil)
 vm.LoadLens("...")
@@ -0,0 +1,210 @@
+// Package engine defines the interface between the LensVM host
+// and the underlying WebAssembly runtime, so the host isn't tied
+// to a single runtime implementation.
+//
+// Values cross the interface as plain Go values, int32, int64,
+// float32 and float64 for the respective wasm value types.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrUnsupported    = errors.New("unsupported by engine")
+)
+
+// ValueType is the type of a wasm value
+type ValueType byte
+
+const (
+	I32 ValueType = iota
+	I64
+	F32
+	F64
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	}
+	return fmt.Sprintf("ValueType(%d)", byte(t))
+}
+
+// Engine compiles wasm modules
+type Engine interface {
+	// Name of the engine, eg. wasmer
+	Name() string
+
+	// Compile compiles the given wasm bytes into a Module
+	// which can be instantiated many times.
+	Compile(wasm []byte) (Module, error)
+
+	// Supports reports if the engine supports the feature
+	Supports(feature Feature) bool
+}
+
+// Feature is an optional feature of an engine
+type Feature int
+
+const (
+	// FeatureInterrupt is supported by engines which stop
+	// a running call when the context of the call is done.
+	// Other engines abandon the call, which keeps running.
+	FeatureInterrupt Feature = iota
+
+	// FeatureReadOnlyDirs is supported by engines which
+	// can preopen read-only WASI directories, see WASI.Dirs
+	FeatureReadOnlyDirs
+)
+
+// Module is a compiled wasm module
+type Module interface {
+	// Instantiate creates a new instance of the module, with
+	// the given host functions as its imports. WASI imports
+	// are provided by the engine, configured by wasi, unless
+	// it's nil. The WASI start function is run, if the module
+	// exports one, and the context bounds its run time.
+	Instantiate(ctx context.Context, imports Imports, wasi *WASI) (Instance, error)
+}
+
+// Instance is an instantiated wasm module
+type Instance interface {
+	// Function returns the exported function with the given
+	// name, or ErrExportNotFound.
+	Function(name string) (Function, error)
+
+	// Memory returns the exported memory with the given
+	// name, or ErrExportNotFound.
+	Memory(name string) (Memory, error)
+
+	// Global returns the exported global with the given
+	// name, or ErrExportNotFound.
+	Global(name string) (Global, error)
+
+	// Close releases the resources of the instance
+	Close() error
+}
+
+// Waiter is implemented by the instances of engines which don't
+// support FeatureInterrupt. Wait waits until the calls into the
+// instance which were abandoned have returned.
+type Waiter interface {
+	Wait()
+}
+
+// Function is an exported wasm function
+type Function interface {
+	Params() []ValueType
+	Results() []ValueType
+
+	// Call calls the function with the given params, and
+	// returns its results. If the context is done before the
+	// call returns, the call is abandoned, and the instance
+	// must not be used anymore.
+	Call(ctx context.Context, params ...interface{}) ([]interface{}, error)
+}
+
+// Memory is an exported linear memory
+type Memory interface {
+	// Data returns the current contents of the memory. The
+	// returned slice is invalidated when the memory grows.
+	Data() []byte
+
+	// Grow grows the memory by the given number of pages,
+	// and reports if it succeeded.
+	Grow(pages uint32) bool
+}
+
+// Global is an exported wasm global
+type Global interface {
+	Type() ValueType
+
+	// Get returns the value of the global
+	Get() (interface{}, error)
+
+	// Set sets the value of a mutable global
+	Set(v interface{}) error
+}
+
+// WASI configures the WASI environment of an instance
+type WASI struct {
+	// Args are the command line arguments,
+	// after the program name
+	Args []string
+
+	// Env are the environment variables
+	Env map[string]string
+
+	// Dirs maps guest paths to host directories,
+	// which are preopened read-only
+	Dirs map[string]string
+
+	// Stdout and Stderr receive the output of the
+	// guest, which is discarded if they are nil
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// callerKey is the context key of the caller memory
+type callerKey struct{}
+
+// WithCaller returns a copy of the context carrying the memory
+// of the instance calling a host function. Engines set it on
+// the context passed to HostFunction.Func, so the host can
+// access the memory even while the instance is starting.
+func WithCaller(ctx context.Context, mem Memory) context.Context {
+	return context.WithValue(ctx, callerKey{}, mem)
+}
+
+// Caller returns the memory of the instance calling a
+// host function, if the engine provided it.
+func Caller(ctx context.Context) (Memory, bool) {
+	mem, ok := ctx.Value(callerKey{}).(Memory)
+	return mem, ok && mem != nil
+}
+
+// HostFunction is a function implemented by the host
+// and imported by a wasm module.
+type HostFunction struct {
+	Params  []ValueType
+	Results []ValueType
+	Func    func(ctx context.Context, params []interface{}) ([]interface{}, error)
+}
+
+// Forward creates a HostFunction that calls the given function,
+// used to link the exports of one instance to the imports
+// of another.
+func Forward(fn Function) HostFunction {
+	return HostFunction{
+		Params:  fn.Params(),
+		Results: fn.Results(),
+		Func: func(ctx context.Context, params []interface{}) ([]interface{}, error) {
+			return fn.Call(ctx, params...)
+		},
+	}
+}
+
+// Imports is a set of host functions keyed by
+// their namespace and name.
+type Imports map[string]map[string]HostFunction
+
+// Register adds the host function to the imports
+// with the given namespace and name.
+func (i Imports) Register(namespace, name string, fn HostFunction) {
+	if i[namespace] == nil {
+		i[namespace] = make(map[string]HostFunction)
+	}
+	i[namespace][name] = fn
+}
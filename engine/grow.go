@@ -0,0 +1,293 @@
+package engine
+
+import "fmt"
+
+// GrowHook is the name of the host function, imported from the env
+// namespace, which grows the memory of a module hooked by HookMemoryGrow
+const GrowHook = "lensvm_memory_grow"
+
+// nameSectionName is the name of the custom section of debug names
+const nameSectionName = "name"
+
+// HookMemoryGrow returns a copy of the wasm module, in which every
+// memory.grow instruction calls the GrowHook host function instead.
+// The hook has the signature of memory.grow, it gets the number of
+// pages to grow by, and returns the previous number of pages, or -1
+// if the memory can't grow, so the host decides if the memory grows.
+//
+// The hook is imported after the other functions, so the index of
+// every function defined by the module is shifted by one. A module
+// which doesn't grow its memory is returned as is.
+func HookMemoryGrow(wasm []byte) ([]byte, error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	imports := findSection(sections, importSectionID)
+	importedFuncs, _, err := importCounts(imports)
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := ImportedFuncs(wasm)
+	if err != nil {
+		return nil, err
+	}
+	if funcs["env"][GrowHook] {
+		return nil, fmt.Errorf("%w: %s is already imported", ErrInvalidModule, GrowHook)
+	}
+
+	hook := uint64(importedFuncs)
+	shift := func(index uint64) uint64 {
+		if index >= hook {
+			return index + 1
+		}
+		return index
+	}
+
+	code := findSection(sections, codeSectionID)
+	if code == nil {
+		return wasm, nil
+	}
+	grows := false
+	code, err = rewriteCode(code, func(body []byte) ([]byte, error) {
+		p := &patcher{reader: reader{buf: body}}
+		for n := p.count(); n > 0 && p.err == nil; n-- {
+			p.uleb()
+			p.byte()
+		}
+		if p.instrs(shift, hook, false) {
+			grows = true
+		}
+		return p.result()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !grows {
+		return wasm, nil
+	}
+	sections = setSection(sections, codeSectionID, code)
+
+	types := findSection(sections, typeSectionID)
+	typeIndex, err := vecCount(types)
+	if err != nil {
+		return nil, err
+	}
+	types, err = appendVec(types, []byte{funcType, 1, valueTypeI32, 1, valueTypeI32})
+	if err != nil {
+		return nil, err
+	}
+	sections = setSection(sections, typeSectionID, types)
+
+	entry := appendName(nil, "env")
+	entry = appendName(entry, GrowHook)
+	entry = append(entry, externFunc)
+	entry = appendULEB(entry, uint64(typeIndex))
+	imports, err = appendVec(imports, entry)
+	if err != nil {
+		return nil, err
+	}
+	sections = setSection(sections, importSectionID, imports)
+
+	for i, s := range sections {
+		var payload []byte
+		switch s.id {
+		case globalSectionID:
+			payload, err = shiftGlobals(s.payload, shift)
+		case exportSectionID:
+			payload, err = shiftExports(s.payload, shift)
+		case startSectionID:
+			payload, err = shiftStart(s.payload, shift)
+		case elemSectionID:
+			payload, err = shiftElements(s.payload, shift)
+		case customSectionID:
+			payload, err = shiftNames(s.payload, shift)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sections[i].payload = payload
+	}
+	return writeSections(wasm, sections), nil
+}
+
+// shiftGlobals shifts the function indices of the global initializers
+func shiftGlobals(payload []byte, shift func(uint64) uint64) ([]byte, error) {
+	p := &patcher{reader: reader{buf: payload}}
+	for n := p.count(); n > 0 && p.err == nil; n-- {
+		p.byte()
+		p.byte()
+		p.instrs(shift, 0, true)
+	}
+	return p.result()
+}
+
+// shiftExports shifts the indices of the exported functions
+func shiftExports(payload []byte, shift func(uint64) uint64) ([]byte, error) {
+	p := &patcher{reader: reader{buf: payload}}
+	for n := p.count(); n > 0 && p.err == nil; n-- {
+		p.name()
+		if p.byte() == externFunc {
+			p.funcIndex(shift)
+		} else {
+			p.uleb()
+		}
+	}
+	return p.result()
+}
+
+// shiftStart shifts the index of the start function
+func shiftStart(payload []byte, shift func(uint64) uint64) ([]byte, error) {
+	p := &patcher{reader: reader{buf: payload}}
+	p.funcIndex(shift)
+	return p.result()
+}
+
+// shiftElements shifts the function indices of the element
+// segments, which are either function indices or expressions
+func shiftElements(payload []byte, shift func(uint64) uint64) ([]byte, error) {
+	p := &patcher{reader: reader{buf: payload}}
+	for n := p.count(); n > 0 && p.err == nil; n-- {
+		flags := p.uleb()
+		if flags > 7 {
+			p.fail("unknown element segment %d", flags)
+			break
+		}
+		// bit 0 is set for passive and declarative segments, bit 1 for
+		// active segments with a table index, or declarative segments,
+		// and bit 2 for segments of expressions
+		if flags&1 == 0 {
+			if flags&2 != 0 {
+				p.uleb()
+			}
+			p.instrs(shift, 0, true)
+		}
+		if flags&3 != 0 {
+			p.byte()
+		}
+		for m := p.count(); m > 0 && p.err == nil; m-- {
+			if flags&4 != 0 {
+				p.instrs(shift, 0, true)
+			} else {
+				p.funcIndex(shift)
+			}
+		}
+	}
+	return p.result()
+}
+
+// shiftNames shifts the function indices of the name section, and
+// returns the payload of any other custom section as is. The function
+// names, and the local and label names, are keyed by function index.
+func shiftNames(payload []byte, shift func(uint64) uint64) ([]byte, error) {
+	p := &patcher{reader: reader{buf: payload}}
+	if p.name() != nameSectionName || p.err != nil {
+		return payload, nil
+	}
+
+	p.flush()
+	for !p.done() {
+		id := p.byte()
+		sub := p.bytes(p.uleb())
+		if p.err != nil {
+			break
+		}
+
+		if id >= 1 && id <= 3 {
+			s := &patcher{reader: reader{buf: sub}}
+			for n := s.count(); n > 0 && s.err == nil; n-- {
+				s.funcIndex(shift)
+				if id == 1 {
+					s.name()
+					continue
+				}
+				for m := s.count(); m > 0 && s.err == nil; m-- {
+					s.uleb()
+					s.name()
+				}
+			}
+			var err error
+			if sub, err = s.result(); err != nil {
+				return nil, err
+			}
+		}
+		p.out = append(p.out, id)
+		p.out = appendULEB(p.out, uint64(len(sub)))
+		p.out = append(p.out, sub...)
+		p.mark = p.off
+	}
+	return p.result()
+}
+
+// patcher copies a payload while decoding it,
+// replacing some of its values on the way
+type patcher struct {
+	reader
+	out  []byte
+	mark int
+}
+
+// flush copies the decoded bytes which aren't copied yet
+func (p *patcher) flush() {
+	p.out = append(p.out, p.buf[p.mark:p.off]...)
+	p.mark = p.off
+}
+
+// replace replaces the bytes decoded since start with b
+func (p *patcher) replace(start int, b []byte) {
+	p.out = append(p.out, p.buf[p.mark:start]...)
+	p.out = append(p.out, b...)
+	p.mark = p.off
+}
+
+// result returns the patched payload
+func (p *patcher) result() ([]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.flush()
+	return p.out, nil
+}
+
+// funcIndex decodes and shifts a function index
+func (p *patcher) funcIndex(shift func(uint64) uint64) {
+	start := p.off
+	index := p.uleb()
+	if p.err == nil {
+		p.replace(start, appendULEB(nil, shift(index)))
+	}
+}
+
+// instrs decodes instructions, until the end of the payload or of the
+// first expression, shifting the function indices, and replacing every
+// memory.grow with a call to the hook. It reports if it replaced any.
+func (p *patcher) instrs(shift func(uint64) uint64, hook uint64, expr bool) bool {
+	grows := false
+	for !p.done() {
+		start := p.off
+		op, index := p.instr()
+		if p.err != nil {
+			break
+		}
+
+		switch op {
+		case opCall, opReturnCall, opRefFunc:
+			p.replace(start, appendULEB([]byte{op}, shift(index)))
+		case opMemoryGrow:
+			if index != 0 {
+				p.err = fmt.Errorf("%w: memory.grow of memory %d", ErrUnsupportedInstruction, index)
+				break
+			}
+			p.replace(start, appendULEB([]byte{opCall}, hook))
+			grows = true
+		case opEnd:
+			if expr {
+				return grows
+			}
+		}
+	}
+	return grows
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// funcExports returns the function indices of the exports of the module
+func funcExports(t *testing.T, wasm []byte) map[string]uint64 {
+	sections, err := readSections(wasm)
+	assert.NoError(t, err)
+
+	exports := make(map[string]uint64)
+	r := &reader{buf: findSection(sections, exportSectionID)}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		name := r.name()
+		kind := r.byte()
+		index := r.uleb()
+		if kind == externFunc {
+			exports[name] = index
+		}
+	}
+	assert.NoError(t, r.err)
+	return exports
+}
+
+// countMemoryGrow returns the number of memory.grow instructions of the module
+func countMemoryGrow(t *testing.T, wasm []byte) int {
+	sections, err := readSections(wasm)
+	assert.NoError(t, err)
+
+	count := 0
+	_, err = rewriteCode(findSection(sections, codeSectionID), func(body []byte) ([]byte, error) {
+		r := &reader{buf: body}
+		for n := r.count(); n > 0 && r.err == nil; n-- {
+			r.uleb()
+			r.byte()
+		}
+		for !r.done() {
+			if op, _ := r.instr(); op == opMemoryGrow {
+				count++
+			}
+		}
+		return body, r.err
+	})
+	assert.NoError(t, err)
+	return count
+}
+
+func TestHookMemoryGrow(t *testing.T) {
+	for _, path := range []string{"../testdata/grow/main.wasm", "../testdata/simple/main.wasm"} {
+		buf, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NotZero(t, countMemoryGrow(t, buf), path)
+
+		hooked, err := HookMemoryGrow(buf)
+		assert.NoError(t, err, path)
+		assert.Zero(t, countMemoryGrow(t, hooked), path)
+
+		funcs, err := ImportedFuncs(hooked)
+		assert.NoError(t, err)
+		assert.True(t, funcs["env"][GrowHook], path)
+
+		// the defined functions are shifted by the hook
+		before, after := funcExports(t, buf), funcExports(t, hooked)
+		for name, index := range before {
+			assert.Equal(t, index+1, after[name], path)
+		}
+
+		// the hook can't be imported twice
+		_, err = HookMemoryGrow(hooked)
+		assert.True(t, errors.Is(err, ErrInvalidModule))
+	}
+
+	// a module which doesn't grow its memory is unchanged
+	buf, err := ioutil.ReadFile("../testdata/loop/main.wasm")
+	assert.NoError(t, err)
+	hooked, err := HookMemoryGrow(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, buf, hooked)
+}
+
+func TestShiftElements(t *testing.T) {
+	shift := func(index uint64) uint64 { return index + 1 }
+
+	// an active segment of function indices at offset 0, and a
+	// passive segment of ref.func expressions, of funcref type
+	payload := []byte{2,
+		0x00, 0x41, 0x00, opEnd, 2, 0, 5,
+		0x05, 0x70, 1, opRefFunc, 7, opEnd,
+	}
+	out, err := shiftElements(payload, shift)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2,
+		0x00, 0x41, 0x00, opEnd, 2, 1, 6,
+		0x05, 0x70, 1, opRefFunc, 8, opEnd,
+	}, out)
+}
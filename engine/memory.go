@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMemoryLimit   = errors.New("memory limit exceeded")
+	ErrInvalidModule = errors.New("invalid wasm module")
+)
+
+const (
+	// the magic and version of a wasm module
+	wasmHeaderSize = 8
+
+	memorySectionID = 5
+
+	// memory flags, the limits have a maximum,
+	// and the valid flags (max, shared, memory64)
+	memoryFlagHasMax = 0x01
+	memoryFlagsMask  = 0x07
+)
+
+var wasmMagic = []byte("\x00asm")
+
+// LimitMemory returns a copy of the wasm module, where the maximum
+// size of every memory defined by the module is at most maxPages,
+// so memory.grow fails past the limit, whatever the engine. It fails
+// with ErrMemoryLimit if a memory requires more than maxPages.
+// Imported memories are left as they are.
+func LimitMemory(wasm []byte, maxPages uint32) ([]byte, error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range sections {
+		if s.id != memorySectionID {
+			continue
+		}
+		sections[i].payload, err = limitMemories(s.payload, maxPages)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return writeSections(wasm, sections), nil
+}
+
+// limitMemories rewrites the limits of the
+// memories in the payload of a memory section
+func limitMemories(payload []byte, maxPages uint32) ([]byte, error) {
+	count, n, err := readULEB(payload)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[n:]
+
+	out := appendULEB(nil, count)
+	for i := uint64(0); i < count; i++ {
+		if len(payload) == 0 {
+			return nil, fmt.Errorf("%w: memory section is truncated", ErrInvalidModule)
+		}
+		flags := payload[0]
+		if flags&^memoryFlagsMask != 0 {
+			return nil, fmt.Errorf("%w: unknown memory flags %#x", ErrInvalidModule, flags)
+		}
+		payload = payload[1:]
+
+		min, n, err := readULEB(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = payload[n:]
+
+		max := uint64(maxPages)
+		if flags&memoryFlagHasMax != 0 {
+			declared, n, err := readULEB(payload)
+			if err != nil {
+				return nil, err
+			}
+			payload = payload[n:]
+			if declared < max {
+				max = declared
+			}
+		}
+
+		if min > uint64(maxPages) {
+			return nil, fmt.Errorf("%w: module requires %d pages of memory, the limit is %d", ErrMemoryLimit, min, maxPages)
+		}
+
+		out = append(out, flags|memoryFlagHasMax)
+		out = appendULEB(out, min)
+		out = appendULEB(out, max)
+	}
+
+	if len(payload) != 0 {
+		return nil, fmt.Errorf("%w: memory section has trailing bytes", ErrInvalidModule)
+	}
+	return out, nil
+}
+
+func readULEB(buf []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(buf) && i < 10; i++ {
+		v |= uint64(buf[i]&0x7f) << (7 * uint(i))
+		if buf[i]&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: malformed integer", ErrInvalidModule)
+}
+
+func appendULEB(buf []byte, v uint64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			buf = append(buf, b|0x80)
+			continue
+		}
+		return append(buf, b)
+	}
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryLimits returns the limits of the first memory
+// of the module, which must have a memory section
+func memoryLimits(t *testing.T, wasm []byte) (byte, uint64, uint64) {
+	rest := wasm[wasmHeaderSize:]
+	for len(rest) > 0 {
+		size, n, err := readULEB(rest[1:])
+		assert.NoError(t, err)
+		payload := rest[1+n : 1+n+int(size)]
+		if rest[0] == memorySectionID {
+			flags := payload[1]
+			min, n, _ := readULEB(payload[2:])
+			max, _, _ := readULEB(payload[2+n:])
+			return flags, min, max
+		}
+		rest = rest[1+n+int(size):]
+	}
+	t.Fatal("missing memory section")
+	return 0, 0, 0
+}
+
+func TestLimitMemory(t *testing.T) {
+	buf, err := ioutil.ReadFile("../testdata/loop/main.wasm")
+	assert.NoError(t, err)
+
+	limited, err := LimitMemory(buf, 300)
+	assert.NoError(t, err)
+	flags, min, max := memoryLimits(t, limited)
+	assert.Equal(t, byte(memoryFlagHasMax), flags)
+	assert.Equal(t, uint64(1), min)
+	assert.Equal(t, uint64(300), max)
+
+	// a lower declared maximum is kept
+	again, err := LimitMemory(limited, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, limited, again)
+
+	_, err = LimitMemory(buf, 0)
+	assert.True(t, errors.Is(err, ErrMemoryLimit))
+
+	_, err = LimitMemory([]byte("not wasm"), 1)
+	assert.True(t, errors.Is(err, ErrInvalidModule))
+
+	_, err = LimitMemory(buf[:len(buf)-2], 1)
+	assert.True(t, errors.Is(err, ErrInvalidModule))
+}
+
+func TestULEB(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 300, 65536, 1<<32 - 1} {
+		buf := appendULEB(nil, v)
+		out, n, err := readULEB(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, len(buf), n)
+		assert.Equal(t, v, out)
+	}
+}
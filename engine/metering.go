@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+)
+
+// FuelGlobal is the name of the exported global
+// holding the remaining fuel of a metered module
+const FuelGlobal = "lensvm_fuel"
+
+// Meter returns a copy of the wasm module instrumented with fuel
+// metering, which works the same whatever the engine. The remaining
+// fuel is kept in a mutable i64 global exported as FuelGlobal, which
+// starts with the given fuel.
+//
+// Fuel is charged on entry of every function, and on every iteration
+// of every loop, by the number of instructions of the function or the
+// loop body, nested loops excluded. Once the fuel is below zero, the
+// guest traps, so a metered guest always ends.
+func Meter(wasm []byte, fuel uint64) ([]byte, error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	_, importedGlobals, err := importCounts(findSection(sections, importSectionID))
+	if err != nil {
+		return nil, err
+	}
+	definedGlobals, err := vecCount(findSection(sections, globalSectionID))
+	if err != nil {
+		return nil, err
+	}
+	exports, err := exportNames(findSection(sections, exportSectionID))
+	if err != nil {
+		return nil, err
+	}
+	if exports[FuelGlobal] {
+		return nil, fmt.Errorf("%w: %s is already exported", ErrInvalidModule, FuelGlobal)
+	}
+	index := uint32(importedGlobals) + definedGlobals
+
+	if fuel > math.MaxInt64 {
+		fuel = math.MaxInt64
+	}
+	global := []byte{valueTypeI64, 1, opI64Const}
+	global = appendSLEB(global, int64(fuel))
+	global = append(global, opEnd)
+	payload, err := appendVec(findSection(sections, globalSectionID), global)
+	if err != nil {
+		return nil, err
+	}
+	sections = setSection(sections, globalSectionID, payload)
+
+	export := appendName(nil, FuelGlobal)
+	export = append(export, externGlobal)
+	export = appendULEB(export, uint64(index))
+	payload, err = appendVec(findSection(sections, exportSectionID), export)
+	if err != nil {
+		return nil, err
+	}
+	sections = setSection(sections, exportSectionID, payload)
+
+	if code := findSection(sections, codeSectionID); code != nil {
+		code, err = rewriteCode(code, func(body []byte) ([]byte, error) {
+			return meterBody(body, index)
+		})
+		if err != nil {
+			return nil, err
+		}
+		sections = setSection(sections, codeSectionID, code)
+	}
+	return writeSections(wasm, sections), nil
+}
+
+// meterBody inserts the fuel charges into a function body
+func meterBody(body []byte, global uint32) ([]byte, error) {
+	r := &reader{buf: body}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		r.uleb()
+		r.byte()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// the costs of the regions, the function body and every
+	// loop body, and the offsets their charges are inserted at
+	costs := []uint64{0}
+	offsets := []int{r.off}
+
+	// the regions of the enclosing blocks
+	var blocks []int
+	region := 0
+	for !r.done() {
+		op, _ := r.instr()
+		costs[region]++
+		switch op {
+		case opBlock, opIf:
+			blocks = append(blocks, region)
+		case opLoop:
+			blocks = append(blocks, region)
+			region = len(costs)
+			costs = append(costs, 0)
+			offsets = append(offsets, r.off)
+		case opEnd:
+			if len(blocks) > 0 {
+				region = blocks[len(blocks)-1]
+				blocks = blocks[:len(blocks)-1]
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	out := make([]byte, 0, len(body)+len(offsets)*24)
+	prev := 0
+	for i, off := range offsets {
+		out = append(out, body[prev:off]...)
+		out = appendCharge(out, global, costs[i])
+		prev = off
+	}
+	return append(out, body[prev:]...), nil
+}
+
+// appendCharge appends the instructions subtracting the cost
+// from the fuel global, which trap if the fuel is below zero
+func appendCharge(out []byte, global uint32, cost uint64) []byte {
+	g := appendULEB(nil, uint64(global))
+
+	out = append(out, opGlobalGet)
+	out = append(out, g...)
+	out = append(out, opI64Const)
+	out = appendSLEB(out, int64(cost))
+	out = append(out, opI64Sub, opGlobalSet)
+	out = append(out, g...)
+
+	out = append(out, opGlobalGet)
+	out = append(out, g...)
+	return append(out, opI64Const, 0, opI64LtS, opIf, blockTypeEmpty, opUnreachable, opEnd)
+}
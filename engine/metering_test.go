@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeter(t *testing.T) {
+	buf, err := ioutil.ReadFile("../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	metered, err := Meter(buf, 1000)
+	assert.NoError(t, err)
+	sections, err := readSections(metered)
+	assert.NoError(t, err)
+	exports, err := exportNames(findSection(sections, exportSectionID))
+	assert.NoError(t, err)
+	assert.True(t, exports[FuelGlobal])
+	assert.True(t, exports["lensvm_exec_rename"])
+
+	// the fuel global can't be added twice
+	_, err = Meter(metered, 1000)
+	assert.True(t, errors.Is(err, ErrInvalidModule))
+
+	_, err = Meter([]byte("not wasm"), 1000)
+	assert.True(t, errors.Is(err, ErrInvalidModule))
+}
+
+func TestMeterBody(t *testing.T) {
+	// no locals, a loop of a nop and a branch, and the end
+	body := []byte{0x00, opLoop, blockTypeEmpty, 0x01, 0x0c, 0x00, opEnd, opEnd}
+	out, err := meterBody(body, 0)
+	assert.NoError(t, err)
+
+	// the function costs the loop and the end, and the
+	// loop body costs the nop, the branch, and the end
+	want := []byte{0x00}
+	want = appendCharge(want, 0, 2)
+	want = append(want, opLoop, blockTypeEmpty)
+	want = appendCharge(want, 0, 3)
+	want = append(want, 0x01, 0x0c, 0x00, opEnd, opEnd)
+	assert.Equal(t, want, out)
+
+	_, err = meterBody([]byte{0x00, 0x06}, 0)
+	assert.True(t, errors.Is(err, ErrUnsupportedInstruction))
+}
+
+func TestSLEB(t *testing.T) {
+	assert.Equal(t, []byte{0x00}, appendSLEB(nil, 0))
+	assert.Equal(t, []byte{0x3f}, appendSLEB(nil, 63))
+	assert.Equal(t, []byte{0xc0, 0x00}, appendSLEB(nil, 64))
+	assert.Equal(t, []byte{0x7f}, appendSLEB(nil, -1))
+	assert.Equal(t, []byte{0xe8, 0x07}, appendSLEB(nil, 1000))
+}
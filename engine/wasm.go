@@ -0,0 +1,469 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupportedInstruction = errors.New("unsupported instruction")
+
+// section ids of a wasm module, besides the memory section
+const (
+	customSectionID = 0
+	typeSectionID   = 1
+	importSectionID = 2
+	globalSectionID = 6
+	exportSectionID = 7
+	startSectionID  = 8
+	elemSectionID   = 9
+	codeSectionID   = 10
+)
+
+// sectionOrder is the order in which the known
+// sections must appear in a module
+var sectionOrder = []byte{1, 2, 3, 4, 5, 13, 6, 7, 8, 9, 12, 10, 11}
+
+// import and export kinds
+const (
+	externFunc   = 0x00
+	externTable  = 0x01
+	externMemory = 0x02
+	externGlobal = 0x03
+	externTag    = 0x04
+)
+
+// opcodes used by the instrumentation
+const (
+	opUnreachable = 0x00
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opEnd         = 0x0b
+	opCall        = 0x10
+	opReturnCall  = 0x12
+	opGlobalGet   = 0x23
+	opGlobalSet   = 0x24
+	opMemoryGrow  = 0x40
+	opI64Const    = 0x42
+	opI64LtS      = 0x53
+	opI64Sub      = 0x7d
+	opRefFunc     = 0xd2
+
+	blockTypeEmpty = 0x40
+	valueTypeI32   = 0x7f
+	valueTypeI64   = 0x7e
+	funcType       = 0x60
+)
+
+// section is a section of a wasm module
+type section struct {
+	id      byte
+	payload []byte
+}
+
+// readSections splits the wasm module into its sections
+func readSections(wasm []byte) ([]section, error) {
+	if len(wasm) < wasmHeaderSize || string(wasm[:4]) != string(wasmMagic) {
+		return nil, ErrInvalidModule
+	}
+
+	var sections []section
+	r := &reader{buf: wasm, off: wasmHeaderSize}
+	for !r.done() {
+		id := r.byte()
+		size := r.uleb()
+		payload := r.bytes(size)
+		if r.err != nil {
+			return nil, fmt.Errorf("%w: section %d is truncated", ErrInvalidModule, id)
+		}
+		sections = append(sections, section{id: id, payload: payload})
+	}
+	return sections, r.err
+}
+
+// writeSections encodes the sections into a wasm module,
+// with the header of the given module
+func writeSections(wasm []byte, sections []section) []byte {
+	out := append([]byte(nil), wasm[:wasmHeaderSize]...)
+	for _, s := range sections {
+		out = append(out, s.id)
+		out = appendULEB(out, uint64(len(s.payload)))
+		out = append(out, s.payload...)
+	}
+	return out
+}
+
+// findSection returns the payload of the section
+// with the given id, or nil if there is none
+func findSection(sections []section, id byte) []byte {
+	for _, s := range sections {
+		if s.id == id {
+			return s.payload
+		}
+	}
+	return nil
+}
+
+// setSection replaces the payload of the section with the given id,
+// or inserts the section before the first section which follows it
+func setSection(sections []section, id byte, payload []byte) []section {
+	for i, s := range sections {
+		if s.id == id {
+			sections[i].payload = payload
+			return sections
+		}
+	}
+
+	at := len(sections)
+	for i, s := range sections {
+		if s.id != customSectionID && orderOf(s.id) > orderOf(id) {
+			at = i
+			break
+		}
+	}
+	sections = append(sections, section{})
+	copy(sections[at+1:], sections[at:])
+	sections[at] = section{id: id, payload: payload}
+	return sections
+}
+
+func orderOf(id byte) int {
+	for i, o := range sectionOrder {
+		if o == id {
+			return i
+		}
+	}
+	return len(sectionOrder)
+}
+
+// vecCount returns the number of entries of the
+// payload of a section, which is a vector of entries
+func vecCount(payload []byte) (uint32, error) {
+	r := &reader{buf: payload}
+	return uint32(r.count()), r.err
+}
+
+// ImportedFuncs returns the names of the functions imported
+// by the wasm module, keyed by their namespace
+func ImportedFuncs(wasm []byte) (map[string]map[string]bool, error) {
+	sections, err := readSections(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := make(map[string]map[string]bool)
+	err = readImports(findSection(sections, importSectionID), func(namespace, name string, kind byte) {
+		if kind != externFunc {
+			return
+		}
+		if funcs[namespace] == nil {
+			funcs[namespace] = make(map[string]bool)
+		}
+		funcs[namespace][name] = true
+	})
+	return funcs, err
+}
+
+// importCounts returns the number of imported
+// functions and globals of the import section
+func importCounts(payload []byte) (funcs, globals uint32, err error) {
+	err = readImports(payload, func(namespace, name string, kind byte) {
+		switch kind {
+		case externFunc:
+			funcs++
+		case externGlobal:
+			globals++
+		}
+	})
+	return funcs, globals, err
+}
+
+// readImports calls fn with every import of the import section
+func readImports(payload []byte, fn func(namespace, name string, kind byte)) error {
+	r := &reader{buf: payload}
+	count := r.count()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		namespace := r.name()
+		name := r.name()
+		kind := r.byte()
+		switch kind {
+		case externFunc:
+			r.uleb()
+		case externTable:
+			r.byte()
+			r.limits()
+		case externMemory:
+			r.limits()
+		case externGlobal:
+			r.byte()
+			r.byte()
+		case externTag:
+			r.byte()
+			r.uleb()
+		default:
+			r.fail("unknown import kind %#x", kind)
+		}
+		if r.err == nil {
+			fn(namespace, name, kind)
+		}
+	}
+	return r.err
+}
+
+// exportNames returns the names of the exports of the export section
+func exportNames(payload []byte) (map[string]bool, error) {
+	names := make(map[string]bool)
+	r := &reader{buf: payload}
+	count := r.count()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		names[r.name()] = true
+		r.byte()
+		r.uleb()
+	}
+	return names, r.err
+}
+
+// appendVec appends an entry to the payload of a section, which is a
+// vector of entries. If the payload is nil, a new vector is created.
+func appendVec(payload []byte, entry []byte) ([]byte, error) {
+	r := &reader{buf: payload}
+	count := r.count()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	out := appendULEB(nil, count+1)
+	out = append(out, payload[r.off:]...)
+	return append(out, entry...), nil
+}
+
+// rewriteCode rewrites every function body of the code section
+func rewriteCode(payload []byte, rewrite func(body []byte) ([]byte, error)) ([]byte, error) {
+	r := &reader{buf: payload}
+	count := r.count()
+	out := appendULEB(nil, count)
+	for i := uint64(0); i < count; i++ {
+		body := r.bytes(r.uleb())
+		if r.err != nil {
+			return nil, r.err
+		}
+		body, err := rewrite(body)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", i, err)
+		}
+		out = appendULEB(out, uint64(len(body)))
+		out = append(out, body...)
+	}
+	return out, r.err
+}
+
+// reader decodes wasm values, the first error
+// is kept, and stops the decoding
+type reader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *reader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidModule}, args...)...)
+	}
+	r.off = len(r.buf)
+}
+
+func (r *reader) done() bool {
+	return r.err != nil || r.off >= len(r.buf)
+}
+
+func (r *reader) byte() byte {
+	if r.off >= len(r.buf) {
+		r.fail("unexpected end")
+		return 0
+	}
+	b := r.buf[r.off]
+	r.off++
+	return b
+}
+
+func (r *reader) bytes(n uint64) []byte {
+	if uint64(len(r.buf)-r.off) < n {
+		r.fail("unexpected end")
+		return nil
+	}
+	b := r.buf[r.off : r.off+int(n)]
+	r.off += int(n)
+	return b
+}
+
+func (r *reader) uleb() uint64 {
+	if r.off >= len(r.buf) {
+		r.fail("unexpected end")
+		return 0
+	}
+	v, n, err := readULEB(r.buf[r.off:])
+	if err != nil {
+		r.fail("malformed integer")
+		return 0
+	}
+	r.off += n
+	return v
+}
+
+// sleb skips a signed integer, whose
+// value isn't needed by the rewrites
+func (r *reader) sleb() {
+	for i := 0; i < 10; i++ {
+		if r.byte()&0x80 == 0 {
+			return
+		}
+	}
+	r.fail("malformed integer")
+}
+
+// count decodes the number of entries of a vector,
+// which is zero if the vector is missing
+func (r *reader) count() uint64 {
+	if len(r.buf) == 0 {
+		return 0
+	}
+	return r.uleb()
+}
+
+func (r *reader) name() string {
+	return string(r.bytes(r.uleb()))
+}
+
+func (r *reader) limits() {
+	flags := r.byte()
+	r.uleb()
+	if flags&memoryFlagHasMax != 0 {
+		r.uleb()
+	}
+}
+
+func (r *reader) memarg() {
+	// multiple memories set bit 6 of the
+	// alignment, followed by the memory index
+	if align := r.uleb(); align&0x40 != 0 {
+		r.uleb()
+	}
+	r.uleb()
+}
+
+func (r *reader) blockType() {
+	if r.off < len(r.buf) {
+		// an empty or value type, or a type index
+		if b := r.buf[r.off]; b == blockTypeEmpty || b >= 0x6f && b <= 0x7f {
+			r.off++
+			return
+		}
+	}
+	r.sleb()
+}
+
+// instr decodes the next instruction, and returns its opcode and the
+// first immediate of instructions with a function or memory index
+func (r *reader) instr() (op byte, index uint64) {
+	op = r.byte()
+	switch {
+	case op == 0x00, op == 0x01, op == 0x05, op == opEnd, op == 0x0f,
+		op == 0x1a, op == 0x1b, op == 0xd1,
+		op >= 0x45 && op <= 0xc4:
+	case op == opBlock, op == opLoop, op == opIf:
+		r.blockType()
+	case op == 0x0c, op == 0x0d:
+		r.uleb()
+	case op == 0x0e:
+		for n := r.uleb(); n > 0 && r.err == nil; n-- {
+			r.uleb()
+		}
+		r.uleb()
+	case op == opCall, op == opReturnCall, op == opRefFunc:
+		index = r.uleb()
+	case op == 0x11, op == 0x13:
+		r.uleb()
+		r.uleb()
+	case op == 0x1c:
+		r.bytes(r.uleb())
+	case op >= 0x20 && op <= 0x26:
+		r.uleb()
+	case op >= 0x28 && op <= 0x3e:
+		r.memarg()
+	case op == 0x3f, op == opMemoryGrow:
+		index = r.uleb()
+	case op == 0x41, op == opI64Const:
+		r.sleb()
+	case op == 0x43:
+		r.bytes(4)
+	case op == 0x44:
+		r.bytes(8)
+	case op == 0xd0:
+		r.byte()
+	case op == 0xfc:
+		r.prefixedFC()
+	case op == 0xfd:
+		r.prefixedFD()
+	case op == 0xfe:
+		if sub := r.uleb(); sub == 0x03 {
+			r.byte()
+		} else {
+			r.memarg()
+		}
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("%w: %#x", ErrUnsupportedInstruction, op)
+		}
+		r.off = len(r.buf)
+	}
+	return op, index
+}
+
+// prefixedFC decodes the saturating truncation,
+// bulk memory and table instructions
+func (r *reader) prefixedFC() {
+	switch sub := r.uleb(); {
+	case sub <= 7:
+	case sub == 8, sub == 10, sub == 12, sub == 14:
+		r.uleb()
+		r.uleb()
+	case sub <= 17:
+		r.uleb()
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("%w: 0xfc %d", ErrUnsupportedInstruction, sub)
+		}
+		r.off = len(r.buf)
+	}
+}
+
+// prefixedFD decodes the vector instructions
+func (r *reader) prefixedFD() {
+	switch sub := r.uleb(); {
+	case sub <= 11, sub == 92, sub == 93:
+		r.memarg()
+	case sub == 12, sub == 13:
+		r.bytes(16)
+	case sub >= 21 && sub <= 34:
+		r.byte()
+	case sub >= 84 && sub <= 91:
+		r.memarg()
+		r.byte()
+	}
+}
+
+func appendSLEB(buf []byte, v int64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func appendName(buf []byte, name string) []byte {
+	buf = appendULEB(buf, uint64(len(name)))
+	return append(buf, name...)
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportedFuncs(t *testing.T) {
+	buf, err := ioutil.ReadFile("../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	funcs, err := ImportedFuncs(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]bool{
+		"env": {
+			"lensvm_get_buffer": true,
+			"lensvm_set_buffer": true,
+		},
+		"wasi_unstable": {
+			"fd_write": true,
+		},
+	}, funcs)
+
+	buf, err = ioutil.ReadFile("../testdata/loop/main.wasm")
+	assert.NoError(t, err)
+	funcs, err = ImportedFuncs(buf)
+	assert.NoError(t, err)
+	assert.Empty(t, funcs)
+
+	_, err = ImportedFuncs([]byte("not wasm"))
+	assert.True(t, errors.Is(err, ErrInvalidModule))
+}
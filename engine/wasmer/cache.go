@@ -0,0 +1,126 @@
+//go:build cgo
+
+package wasmer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/lens-vm/lens-vm-go-host/internal/fsutil"
+
+	wasmergo "github.com/wasmerio/wasmer-go/wasmer"
+)
+
+const (
+	// cacheMagic prefixes every cached module, and is
+	// changed when the cache file format changes
+	cacheMagic = "lensvm-wasmer-v1\n"
+
+	wasmerModule = "github.com/wasmerio/wasmer-go"
+)
+
+// moduleCache stores serialized compiled modules on disk. Compiled
+// modules are only compatible with the same wasmer version and
+// target, so the entries are stored in a directory per version and
+// target, and are keyed by the sha256 hash of the wasm bytes.
+type moduleCache struct {
+	dir string
+}
+
+func newModuleCache(dir string) (*moduleCache, error) {
+	dir = filepath.Join(dir, cacheVersion())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &moduleCache{dir: dir}, nil
+}
+
+// cacheVersion identifies the wasmer version and
+// target the compiled modules are compatible with
+func cacheVersion() string {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path != wasmerModule {
+				continue
+			}
+			version = dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+		}
+	}
+	return "wasmer-" + version + "-" + runtime.GOOS + "-" + runtime.GOARCH
+}
+
+func (c *moduleCache) path(wasm []byte) string {
+	sum := sha256.Sum256(wasm)
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// load returns the cached compiled module of the given wasm
+// bytes. It reports a miss if there is no entry, or the entry
+// is corrupt or incompatible, in which case it is removed.
+func (c *moduleCache) load(store *wasmergo.Store, wasm []byte) (*wasmergo.Module, bool) {
+	path := c.path(wasm)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	artifact, ok := unpackEntry(buf)
+	if !ok {
+		os.Remove(path)
+		return nil, false
+	}
+
+	mod, err := wasmergo.DeserializeModule(store, artifact)
+	if err != nil {
+		os.Remove(path)
+		return nil, false
+	}
+	return mod, true
+}
+
+// store serializes the compiled module of the given wasm bytes
+// into the cache
+func (c *moduleCache) store(wasm []byte, mod *wasmergo.Module) error {
+	artifact, err := mod.Serialize()
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(c.path(wasm), packEntry(artifact))
+}
+
+// packEntry prefixes the artifact with the cache magic and its
+// sha256 hash, so a corrupt entry is never deserialized.
+func packEntry(artifact []byte) []byte {
+	sum := sha256.Sum256(artifact)
+	buf := make([]byte, 0, len(cacheMagic)+len(sum)+len(artifact))
+	buf = append(buf, cacheMagic...)
+	buf = append(buf, sum[:]...)
+	return append(buf, artifact...)
+}
+
+func unpackEntry(buf []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(buf, []byte(cacheMagic)) {
+		return nil, false
+	}
+	buf = buf[len(cacheMagic):]
+	if len(buf) < sha256.Size {
+		return nil, false
+	}
+
+	artifact := buf[sha256.Size:]
+	sum := sha256.Sum256(artifact)
+	if !bytes.Equal(sum[:], buf[:sha256.Size]) {
+		return nil, false
+	}
+	return artifact, true
+}
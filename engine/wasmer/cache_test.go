@@ -0,0 +1,78 @@
+//go:build cgo
+
+package wasmer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lensvm-wasmer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	e, err := NewWithOptions(Options{CacheDir: dir})
+	assert.NoError(t, err)
+	_, err = e.Compile(buf)
+	assert.NoError(t, err)
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, cacheVersion()))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// a new engine loads the module from the cache
+	e, err = NewWithOptions(Options{CacheDir: dir})
+	assert.NoError(t, err)
+	mod, ok := e.cache.load(e.store, buf)
+	assert.True(t, ok)
+	assert.NotNil(t, mod)
+}
+
+func TestCompileCorruptCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lensvm-wasmer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	e, err := NewWithOptions(Options{CacheDir: dir})
+	assert.NoError(t, err)
+	path := e.cache.path(buf)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(cacheMagic+"garbage"), 0644))
+
+	_, ok := e.cache.load(e.store, buf)
+	assert.False(t, ok)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// falls back to compiling, and replaces the entry
+	_, err = e.Compile(buf)
+	assert.NoError(t, err)
+	_, ok = e.cache.load(e.store, buf)
+	assert.True(t, ok)
+}
+
+func TestPackEntry(t *testing.T) {
+	artifact := []byte("compiled module")
+	buf := packEntry(artifact)
+
+	out, ok := unpackEntry(buf)
+	assert.True(t, ok)
+	assert.Equal(t, artifact, out)
+
+	buf[len(buf)-1] ^= 0xff
+	_, ok = unpackEntry(buf)
+	assert.False(t, ok)
+
+	_, ok = unpackEntry([]byte("not a cache entry"))
+	assert.False(t, ok)
+}
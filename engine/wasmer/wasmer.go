@@ -0,0 +1,437 @@
+//go:build cgo
+
+// Package wasmer implements the engine interface
+// with the wasmer-go runtime. It requires cgo.
+package wasmer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+
+	wasmergo "github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// programName is the name of the program
+// in the WASI command line arguments
+const programName = "lensvm-go-host"
+
+// Options configures a wasmer engine
+type Options struct {
+	// CacheDir if set, is the directory compiled modules
+	// are cached in, so they are only compiled once. It
+	// can be shared by many engines and processes.
+	CacheDir string
+}
+
+// Engine is the wasmer implementation of engine.Engine
+type Engine struct {
+	engine *wasmergo.Engine
+	store  *wasmergo.Store
+
+	cache *moduleCache
+}
+
+// New creates a new wasmer engine, with
+// its own store.
+func New() *Engine {
+	return newEngine(wasmergo.NewEngine())
+}
+
+// NewWithOptions creates a new wasmer engine
+// configured with the given options.
+func NewWithOptions(opts Options) (*Engine, error) {
+	e := New()
+	if opts.CacheDir != "" {
+		cache, err := newModuleCache(opts.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+		e.cache = cache
+	}
+	return e, nil
+}
+
+func newEngine(e *wasmergo.Engine) *Engine {
+	return &Engine{
+		engine: e,
+		store:  wasmergo.NewStore(e),
+	}
+}
+
+func (e *Engine) Name() string {
+	return "wasmer"
+}
+
+// Supports reports if the engine supports the feature. Wasmer
+// can't interrupt a running call, see instance.call, and can't
+// preopen read-only directories, see newWasiEnv.
+func (e *Engine) Supports(feature engine.Feature) bool {
+	return false
+}
+
+// Compile compiles the wasm bytes, or loads the compiled module
+// from the cache, if enabled. Cache failures aren't fatal, the
+// module is compiled instead.
+func (e *Engine) Compile(wasm []byte) (engine.Module, error) {
+	if e.cache != nil {
+		if mod, ok := e.cache.load(e.store, wasm); ok {
+			return &module{engine: e, mod: mod}, nil
+		}
+	}
+
+	mod, err := wasmergo.NewModule(e.store, wasm)
+	if err != nil {
+		return nil, err
+	}
+	if e.cache != nil {
+		e.cache.store(wasm, mod)
+	}
+	return &module{engine: e, mod: mod}, nil
+}
+
+type module struct {
+	engine *Engine
+	mod    *wasmergo.Module
+}
+
+func (m *module) Instantiate(ctx context.Context, imports engine.Imports, wasi *engine.WASI) (engine.Instance, error) {
+	importObj := wasmergo.NewImportObject()
+	var env *wasiEnv
+	// wasmer can't generate the WASI imports of
+	// a module which doesn't import WASI at all
+	if wasi != nil && wasmergo.GetWasiVersion(m.mod) != wasmergo.WASI_VERSION_INVALID {
+		var err error
+		env, err = newWasiEnv(wasi)
+		if err != nil {
+			return nil, err
+		}
+		importObj, err = env.env.GenerateImportObject(m.engine.store, m.mod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	caller := &callerMemory{}
+	for namespace, funcs := range imports {
+		externs := make(map[string]wasmergo.IntoExtern, len(funcs))
+		for name, fn := range funcs {
+			externs[name] = m.engine.newFunction(fn, caller)
+		}
+		importObj.Register(namespace, externs)
+	}
+
+	inst, err := wasmergo.NewInstance(m.mod, importObj)
+	if err != nil {
+		return nil, err
+	}
+	if mem, err := inst.Exports.GetMemory("memory"); err == nil {
+		caller.mem = memory{mem: mem}
+	}
+	i := &instance{inst: inst, wasi: env}
+
+	if start, err := inst.Exports.GetWasiStartFunction(); err == nil {
+		_, err := i.call(ctx, start)
+		i.flush()
+		if err != nil {
+			i.Close()
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+// callerMemory is the memory of the instance importing host
+// functions, which is set once the instance is created, since
+// wasmer doesn't pass the caller into host functions.
+type callerMemory struct {
+	mem engine.Memory
+}
+
+// newFunction creates the wasmer function of the host function.
+// Wasmer doesn't pass a context into host functions, so nested
+// calls are only bounded by the context of the outer call.
+func (e *Engine) newFunction(fn engine.HostFunction, caller *callerMemory) *wasmergo.Function {
+	fnType := wasmergo.NewFunctionType(toValueTypes(fn.Params), toValueTypes(fn.Results))
+	return wasmergo.NewFunction(e.store, fnType, func(args []wasmergo.Value) ([]wasmergo.Value, error) {
+		params := make([]interface{}, len(args))
+		for i, arg := range args {
+			params[i] = arg.Unwrap()
+		}
+
+		ctx := context.Background()
+		if caller.mem != nil {
+			ctx = engine.WithCaller(ctx, caller.mem)
+		}
+
+		results, err := fn.Func(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) != len(fn.Results) {
+			return nil, fmt.Errorf("host function returned %d results, expected %d", len(results), len(fn.Results))
+		}
+
+		ret := make([]wasmergo.Value, len(results))
+		for i, res := range results {
+			ret[i] = wasmergo.NewValue(res, toValueKind(fn.Results[i]))
+		}
+		return ret, nil
+	})
+}
+
+// wasiEnv is a WASI environment, which captures the output
+// of the guest, since wasmer can't write it into a writer.
+type wasiEnv struct {
+	env    *wasmergo.WasiEnvironment
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// newWasiEnv creates the WASI environment. Wasmer preopens
+// directories read-write, so read-only directories aren't
+// supported.
+func newWasiEnv(wasi *engine.WASI) (*wasiEnv, error) {
+	if len(wasi.Dirs) > 0 {
+		return nil, fmt.Errorf("%w: read-only preopened directories", engine.ErrUnsupported)
+	}
+
+	builder := wasmergo.NewWasiStateBuilder(programName)
+	for _, arg := range wasi.Args {
+		builder = builder.Argument(arg)
+	}
+	keys := make([]string, 0, len(wasi.Env))
+	for k := range wasi.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		builder = builder.Environment(k, wasi.Env[k])
+	}
+	builder = builder.CaptureStdout().CaptureStderr()
+
+	env, err := builder.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	return &wasiEnv{
+		env:    env,
+		stdout: orDiscard(wasi.Stdout),
+		stderr: orDiscard(wasi.Stderr),
+	}, nil
+}
+
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+type instance struct {
+	inst *wasmergo.Instance
+	wasi *wasiEnv
+
+	// mu serializes flushing the captured output
+	mu sync.Mutex
+
+	// calls tracks the calls which may still be
+	// running after their context is done
+	calls sync.WaitGroup
+}
+
+func (i *instance) Function(name string) (engine.Function, error) {
+	fn, err := i.inst.Exports.GetRawFunction(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", engine.ErrExportNotFound, name)
+	}
+	return function{inst: i, fn: fn}, nil
+}
+
+func (i *instance) Memory(name string) (engine.Memory, error) {
+	mem, err := i.inst.Exports.GetMemory(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", engine.ErrExportNotFound, name)
+	}
+	return memory{mem: mem}, nil
+}
+
+func (i *instance) Global(name string) (engine.Global, error) {
+	g, err := i.inst.Exports.GetGlobal(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", engine.ErrExportNotFound, name)
+	}
+	return global{g: g}, nil
+}
+
+// Wait waits until the abandoned calls
+// into the instance have returned
+func (i *instance) Wait() {
+	i.calls.Wait()
+}
+
+// Close closes the instance, once all the
+// calls into the instance have returned.
+func (i *instance) Close() error {
+	go func() {
+		i.calls.Wait()
+		i.inst.Close()
+	}()
+	return nil
+}
+
+// flush writes the output the guest wrote since the last
+// flush into the writers of the WASI environment.
+func (i *instance) flush() {
+	if i.wasi == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if out := i.wasi.env.ReadStdout(); len(out) > 0 {
+		i.wasi.stdout.Write(out)
+	}
+	if out := i.wasi.env.ReadStderr(); len(out) > 0 {
+		i.wasi.stderr.Write(out)
+	}
+}
+
+// call calls the native function. Wasmer can't interrupt a running
+// call, so if the context can be done, the call runs on its own
+// goroutine, and is abandoned when the context is done. An abandoned
+// call keeps running, unless the module is metered, see engine.Meter,
+// in which case it ends once it runs out of fuel.
+func (i *instance) call(ctx context.Context, fn wasmergo.NativeFunction, params ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return fn(params...)
+	}
+
+	type result struct {
+		res interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	i.calls.Add(1)
+	go func() {
+		defer i.calls.Done()
+		res, err := fn(params...)
+		done <- result{res, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type function struct {
+	inst *instance
+	fn   *wasmergo.Function
+}
+
+func (f function) Params() []engine.ValueType {
+	return fromValueTypes(f.fn.Type().Params())
+}
+
+func (f function) Results() []engine.ValueType {
+	return fromValueTypes(f.fn.Type().Results())
+}
+
+// Call calls the function, and normalizes the wasmer
+// results, which are either nil, a single value, or
+// a slice of values, into a slice.
+func (f function) Call(ctx context.Context, params ...interface{}) ([]interface{}, error) {
+	res, err := f.inst.call(ctx, f.fn.Call, params...)
+	f.inst.flush()
+	if err != nil {
+		return nil, err
+	}
+
+	switch res := res.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return res, nil
+	default:
+		return []interface{}{res}, nil
+	}
+}
+
+type memory struct {
+	mem *wasmergo.Memory
+}
+
+func (m memory) Data() []byte {
+	return m.mem.Data()
+}
+
+func (m memory) Grow(pages uint32) bool {
+	return m.mem.Grow(wasmergo.Pages(pages))
+}
+
+type global struct {
+	g *wasmergo.Global
+}
+
+func (g global) Type() engine.ValueType {
+	return fromValueKind(g.g.Type().ValueType().Kind())
+}
+
+func (g global) Get() (interface{}, error) {
+	return g.g.Get()
+}
+
+func (g global) Set(v interface{}) error {
+	return g.g.Set(v, g.g.Type().ValueType().Kind())
+}
+
+func toValueKind(t engine.ValueType) wasmergo.ValueKind {
+	switch t {
+	case engine.I64:
+		return wasmergo.I64
+	case engine.F32:
+		return wasmergo.F32
+	case engine.F64:
+		return wasmergo.F64
+	}
+	return wasmergo.I32
+}
+
+func toValueTypes(types []engine.ValueType) []*wasmergo.ValueType {
+	kinds := make([]wasmergo.ValueKind, len(types))
+	for i, t := range types {
+		kinds[i] = toValueKind(t)
+	}
+	return wasmergo.NewValueTypes(kinds...)
+}
+
+func fromValueTypes(types []*wasmergo.ValueType) []engine.ValueType {
+	ret := make([]engine.ValueType, len(types))
+	for i, t := range types {
+		ret[i] = fromValueKind(t.Kind())
+	}
+	return ret
+}
+
+func fromValueKind(kind wasmergo.ValueKind) engine.ValueType {
+	switch kind {
+	case wasmergo.I64:
+		return engine.I64
+	case wasmergo.F32:
+		return engine.F32
+	case wasmergo.F64:
+		return engine.F64
+	}
+	return engine.I32
+}
@@ -0,0 +1,129 @@
+//go:build cgo
+
+package wasmer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bufferFunc() engine.HostFunction {
+	return engine.HostFunction{
+		Params:  []engine.ValueType{engine.I32, engine.I32, engine.I32, engine.I32, engine.I32},
+		Results: []engine.ValueType{engine.I32},
+		Func: func(ctx context.Context, params []interface{}) ([]interface{}, error) {
+			return []interface{}{int32(0)}, nil
+		},
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	e := New()
+	assert.Equal(t, "wasmer", e.Name())
+
+	mod, err := e.Compile(buf)
+	assert.NoError(t, err)
+
+	imports := make(engine.Imports)
+	imports.Register("env", "lensvm_get_buffer", bufferFunc())
+	imports.Register("env", "lensvm_set_buffer", bufferFunc())
+	inst, err := mod.Instantiate(context.Background(), imports, &engine.WASI{})
+	assert.NoError(t, err)
+	defer inst.Close()
+
+	fn, err := inst.Function("lensvm_exec_rename")
+	assert.NoError(t, err)
+	assert.Equal(t, []engine.ValueType{engine.I32, engine.I32, engine.I32, engine.I32, engine.I32}, fn.Params())
+	assert.Equal(t, []engine.ValueType{engine.I32}, fn.Results())
+
+	mem, err := inst.Memory("memory")
+	assert.NoError(t, err)
+	size := len(mem.Data())
+	assert.True(t, mem.Grow(1))
+	assert.Equal(t, size+65536, len(mem.Data()))
+
+	_, err = inst.Function("missing")
+	assert.True(t, errors.Is(err, engine.ErrExportNotFound))
+}
+
+func TestCompileInvalid(t *testing.T) {
+	_, err := New().Compile([]byte("not wasm"))
+	assert.Error(t, err)
+}
+
+// instantiateLoop instantiates the loop module
+// metered with the given fuel
+func instantiateLoop(t *testing.T, fuel uint64) engine.Instance {
+	buf, err := ioutil.ReadFile("../../testdata/loop/main.wasm")
+	assert.NoError(t, err)
+	buf, err = engine.Meter(buf, fuel)
+	assert.NoError(t, err)
+
+	mod, err := New().Compile(buf)
+	assert.NoError(t, err)
+	inst, err := mod.Instantiate(context.Background(), make(engine.Imports), nil)
+	assert.NoError(t, err)
+	return inst
+}
+
+func TestFuelExhausted(t *testing.T) {
+	inst := instantiateLoop(t, 1000)
+	defer inst.Close()
+
+	fuel, err := inst.Global(engine.FuelGlobal)
+	assert.NoError(t, err)
+	assert.Equal(t, engine.I64, fuel.Type())
+	assert.NoError(t, fuel.Set(int64(500)))
+
+	fn, err := inst.Function("lensvm_exec_loop")
+	assert.NoError(t, err)
+	_, err = fn.Call(context.Background(), int32(0), int32(0), int32(0), int32(0), int32(0))
+	assert.Error(t, err)
+
+	left, err := fuel.Get()
+	assert.NoError(t, err)
+	assert.True(t, left.(int64) < 0)
+
+	_, err = inst.Global("missing")
+	assert.True(t, errors.Is(err, engine.ErrExportNotFound))
+}
+
+func TestCallTimeout(t *testing.T) {
+	assert.False(t, New().Supports(engine.FeatureInterrupt))
+
+	// wasmer abandons the call, which ends
+	// once it runs out of fuel
+	inst := instantiateLoop(t, 1e9)
+	defer inst.Close()
+
+	fn, err := inst.Function("lensvm_exec_loop")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = fn.Call(ctx, int32(0), int32(0), int32(0), int32(0), int32(0))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestInstantiateReadOnlyDirs(t *testing.T) {
+	assert.False(t, New().Supports(engine.FeatureReadOnlyDirs))
+
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	mod, err := New().Compile(buf)
+	assert.NoError(t, err)
+	_, err = mod.Instantiate(context.Background(), make(engine.Imports), &engine.WASI{
+		Dirs: map[string]string{"/data": "."},
+	})
+	assert.True(t, errors.Is(err, engine.ErrUnsupported))
+}
@@ -0,0 +1,398 @@
+// Package wazero implements the engine interface with
+// the pure Go wazero runtime, which doesn't require cgo.
+package wazero
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// programName is the name of the program
+// in the WASI command line arguments
+const programName = "lensvm-go-host"
+
+// Engine is the wazero implementation of engine.Engine.
+//
+// Every instance gets its own wazero runtime, since all the
+// instances import their host functions from the same "env"
+// namespace. The compiled code is shared between the runtimes
+// with a compilation cache, so modules are only compiled once.
+type Engine struct {
+	cache wazero.CompilationCache
+}
+
+// Options configures a wazero engine
+type Options struct {
+	// CacheDir if set, is the directory compiled modules
+	// are cached in, so they are only compiled once. It
+	// can be shared by many engines and processes.
+	CacheDir string
+}
+
+// New creates a new wazero engine
+func New() *Engine {
+	return &Engine{
+		cache: wazero.NewCompilationCache(),
+	}
+}
+
+// NewWithOptions creates a new wazero engine
+// configured with the given options.
+func NewWithOptions(opts Options) (*Engine, error) {
+	if opts.CacheDir == "" {
+		return New(), nil
+	}
+
+	cache, err := wazero.NewCompilationCacheWithDir(opts.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{cache: cache}, nil
+}
+
+func (e *Engine) Name() string {
+	return "wazero"
+}
+
+// Supports reports if the engine supports the feature
+func (e *Engine) Supports(feature engine.Feature) bool {
+	return feature == engine.FeatureInterrupt || feature == engine.FeatureReadOnlyDirs
+}
+
+func (e *Engine) Compile(wasm []byte) (engine.Module, error) {
+	ctx := context.Background()
+	r := e.newRuntime(ctx)
+	defer r.Close(ctx)
+
+	// compile once to validate the module,
+	// and to populate the compilation cache
+	if _, err := r.CompileModule(ctx, wasm); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, len(wasm))
+	copy(buf, wasm)
+	return &module{engine: e, wasm: buf}, nil
+}
+
+// newRuntime creates a runtime which closes the running
+// module when the context of a call is done.
+func (e *Engine) newRuntime(ctx context.Context) wazero.Runtime {
+	config := wazero.NewRuntimeConfig().
+		WithCompilationCache(e.cache).
+		WithCloseOnContextDone(true)
+	return wazero.NewRuntimeWithConfig(ctx, config)
+}
+
+type module struct {
+	engine *Engine
+	wasm   []byte
+}
+
+func (m *module) Instantiate(ctx context.Context, imports engine.Imports, wasi *engine.WASI) (engine.Instance, error) {
+	r := m.engine.newRuntime(ctx)
+	inst, err := m.instantiate(ctx, r, imports, wasi)
+	if err != nil {
+		r.Close(context.Background())
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (m *module) instantiate(ctx context.Context, r wazero.Runtime, imports engine.Imports, wasi *engine.WASI) (*instance, error) {
+	compiled, err := r.CompileModule(ctx, m.wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	if wasi != nil {
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+			return nil, err
+		}
+		// older guests, eg. TinyGo, import WASI as wasi_unstable
+		unstable := r.NewHostModuleBuilder("wasi_unstable")
+		wasi_snapshot_preview1.NewFunctionExporter().ExportFunctions(unstable)
+		if _, err := unstable.Instantiate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	for namespace, funcs := range imports {
+		builder := r.NewHostModuleBuilder(namespace)
+		for name, fn := range funcs {
+			builder.NewFunctionBuilder().
+				WithGoModuleFunction(hostFunction(fn), toValueTypes(fn.Params), toValueTypes(fn.Results)).
+				Export(name)
+		}
+		if _, err := builder.Instantiate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// wazero runs the WASI start function, if
+	// any, when the module is instantiated
+	mod, err := r.InstantiateModule(ctx, compiled, moduleConfig(wasi))
+	if err != nil {
+		return nil, err
+	}
+	return &instance{runtime: r, mod: mod}, nil
+}
+
+// moduleConfig returns the module config of the WASI environment
+func moduleConfig(wasi *engine.WASI) wazero.ModuleConfig {
+	config := wazero.NewModuleConfig()
+	if wasi == nil {
+		return config
+	}
+
+	config = config.WithArgs(append([]string{programName}, wasi.Args...)...)
+	for _, k := range sortedKeys(wasi.Env) {
+		config = config.WithEnv(k, wasi.Env[k])
+	}
+
+	if len(wasi.Dirs) > 0 {
+		fsConfig := wazero.NewFSConfig()
+		for _, guest := range sortedKeys(wasi.Dirs) {
+			fsConfig = fsConfig.WithReadOnlyDirMount(wasi.Dirs[guest], guest)
+		}
+		config = config.WithFSConfig(fsConfig)
+	}
+
+	if wasi.Stdout != nil {
+		config = config.WithStdout(wasi.Stdout)
+	}
+	if wasi.Stderr != nil {
+		config = config.WithStderr(wasi.Stderr)
+	}
+	return config
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hostFunction adapts the host function to the wazero stack
+// based calling convention. Errors are raised as panics, which
+// wazero recovers from, and returns from the guest call.
+func hostFunction(fn engine.HostFunction) api.GoModuleFunc {
+	return func(ctx context.Context, caller api.Module, stack []uint64) {
+		if mem := caller.Memory(); mem != nil {
+			ctx = engine.WithCaller(ctx, memory{mem: mem})
+		}
+
+		params := make([]interface{}, len(fn.Params))
+		for i, t := range fn.Params {
+			params[i] = decodeValue(t, stack[i])
+		}
+
+		results, err := fn.Func(ctx, params)
+		if err != nil {
+			panic(err)
+		}
+		if len(results) != len(fn.Results) {
+			panic(fmt.Errorf("host function returned %d results, expected %d", len(results), len(fn.Results)))
+		}
+
+		for i, t := range fn.Results {
+			v, err := encodeValue(t, results[i])
+			if err != nil {
+				panic(err)
+			}
+			stack[i] = v
+		}
+	}
+}
+
+type instance struct {
+	runtime wazero.Runtime
+	mod     api.Module
+}
+
+func (i *instance) Function(name string) (engine.Function, error) {
+	fn := i.mod.ExportedFunction(name)
+	if fn == nil {
+		return nil, fmt.Errorf("%w: %s", engine.ErrExportNotFound, name)
+	}
+	return function{fn: fn}, nil
+}
+
+func (i *instance) Memory(name string) (engine.Memory, error) {
+	mem := i.mod.ExportedMemory(name)
+	if mem == nil {
+		return nil, fmt.Errorf("%w: %s", engine.ErrExportNotFound, name)
+	}
+	return memory{mem: mem}, nil
+}
+
+func (i *instance) Global(name string) (engine.Global, error) {
+	g := i.mod.ExportedGlobal(name)
+	if g == nil {
+		return nil, fmt.Errorf("%w: %s", engine.ErrExportNotFound, name)
+	}
+	return global{g: g}, nil
+}
+
+func (i *instance) Close() error {
+	return i.runtime.Close(context.Background())
+}
+
+type function struct {
+	fn api.Function
+}
+
+func (f function) Params() []engine.ValueType {
+	return fromValueTypes(f.fn.Definition().ParamTypes())
+}
+
+func (f function) Results() []engine.ValueType {
+	return fromValueTypes(f.fn.Definition().ResultTypes())
+}
+
+func (f function) Call(ctx context.Context, params ...interface{}) ([]interface{}, error) {
+	def := f.fn.Definition()
+	paramTypes := fromValueTypes(def.ParamTypes())
+	if len(params) != len(paramTypes) {
+		return nil, fmt.Errorf("function %s expects %d params, got %d", def.Name(), len(paramTypes), len(params))
+	}
+
+	stack := make([]uint64, len(params))
+	for i, p := range params {
+		v, err := encodeValue(paramTypes[i], p)
+		if err != nil {
+			return nil, err
+		}
+		stack[i] = v
+	}
+
+	res, err := f.fn.Call(ctx, stack...)
+	if err != nil {
+		return nil, err
+	}
+
+	resultTypes := fromValueTypes(def.ResultTypes())
+	results := make([]interface{}, len(res))
+	for i, v := range res {
+		results[i] = decodeValue(resultTypes[i], v)
+	}
+	return results, nil
+}
+
+type memory struct {
+	mem api.Memory
+}
+
+func (m memory) Data() []byte {
+	data, _ := m.mem.Read(0, m.mem.Size())
+	return data
+}
+
+func (m memory) Grow(pages uint32) bool {
+	_, ok := m.mem.Grow(pages)
+	return ok
+}
+
+type global struct {
+	g api.Global
+}
+
+func (g global) Type() engine.ValueType {
+	return fromValueTypes([]api.ValueType{g.g.Type()})[0]
+}
+
+func (g global) Get() (interface{}, error) {
+	return decodeValue(g.Type(), g.g.Get()), nil
+}
+
+func (g global) Set(v interface{}) error {
+	mut, ok := g.g.(api.MutableGlobal)
+	if !ok {
+		return fmt.Errorf("global is immutable")
+	}
+	val, err := encodeValue(g.Type(), v)
+	if err != nil {
+		return err
+	}
+	mut.Set(val)
+	return nil
+}
+
+func encodeValue(t engine.ValueType, v interface{}) (uint64, error) {
+	switch t {
+	case engine.I32:
+		if v, ok := v.(int32); ok {
+			return api.EncodeI32(v), nil
+		}
+	case engine.I64:
+		if v, ok := v.(int64); ok {
+			return uint64(v), nil
+		}
+	case engine.F32:
+		if v, ok := v.(float32); ok {
+			return api.EncodeF32(v), nil
+		}
+	case engine.F64:
+		if v, ok := v.(float64); ok {
+			return math.Float64bits(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s value: %v (%T)", t, v, v)
+}
+
+func decodeValue(t engine.ValueType, v uint64) interface{} {
+	switch t {
+	case engine.I64:
+		return int64(v)
+	case engine.F32:
+		return api.DecodeF32(v)
+	case engine.F64:
+		return math.Float64frombits(v)
+	}
+	return api.DecodeI32(v)
+}
+
+func toValueTypes(types []engine.ValueType) []api.ValueType {
+	ret := make([]api.ValueType, len(types))
+	for i, t := range types {
+		switch t {
+		case engine.I64:
+			ret[i] = api.ValueTypeI64
+		case engine.F32:
+			ret[i] = api.ValueTypeF32
+		case engine.F64:
+			ret[i] = api.ValueTypeF64
+		default:
+			ret[i] = api.ValueTypeI32
+		}
+	}
+	return ret
+}
+
+func fromValueTypes(types []api.ValueType) []engine.ValueType {
+	ret := make([]engine.ValueType, len(types))
+	for i, t := range types {
+		switch t {
+		case api.ValueTypeI64:
+			ret[i] = engine.I64
+		case api.ValueTypeF32:
+			ret[i] = engine.F32
+		case api.ValueTypeF64:
+			ret[i] = engine.F64
+		default:
+			ret[i] = engine.I32
+		}
+	}
+	return ret
+}
@@ -0,0 +1,142 @@
+package wazero
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bufferFunc() engine.HostFunction {
+	return engine.HostFunction{
+		Params:  []engine.ValueType{engine.I32, engine.I32, engine.I32, engine.I32, engine.I32},
+		Results: []engine.ValueType{engine.I32},
+		Func: func(ctx context.Context, params []interface{}) ([]interface{}, error) {
+			return []interface{}{int32(0)}, nil
+		},
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	e := New()
+	assert.Equal(t, "wazero", e.Name())
+
+	mod, err := e.Compile(buf)
+	assert.NoError(t, err)
+
+	imports := make(engine.Imports)
+	imports.Register("env", "lensvm_get_buffer", bufferFunc())
+	imports.Register("env", "lensvm_set_buffer", bufferFunc())
+	inst, err := mod.Instantiate(context.Background(), imports, &engine.WASI{})
+	assert.NoError(t, err)
+	defer inst.Close()
+
+	fn, err := inst.Function("lensvm_exec_rename")
+	assert.NoError(t, err)
+	assert.Equal(t, []engine.ValueType{engine.I32, engine.I32, engine.I32, engine.I32, engine.I32}, fn.Params())
+	assert.Equal(t, []engine.ValueType{engine.I32}, fn.Results())
+
+	mem, err := inst.Memory("memory")
+	assert.NoError(t, err)
+	size := len(mem.Data())
+	assert.True(t, mem.Grow(1))
+	assert.Equal(t, size+65536, len(mem.Data()))
+
+	_, err = inst.Function("missing")
+	assert.True(t, errors.Is(err, engine.ErrExportNotFound))
+}
+
+func TestCompileInvalid(t *testing.T) {
+	_, err := New().Compile([]byte("not wasm"))
+	assert.Error(t, err)
+}
+
+func TestCompileCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lensvm-wazero")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	e, err := NewWithOptions(Options{CacheDir: dir})
+	assert.NoError(t, err)
+	_, err = e.Compile(buf)
+	assert.NoError(t, err)
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, entries)
+}
+
+func TestFuelExhausted(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../testdata/loop/main.wasm")
+	assert.NoError(t, err)
+	buf, err = engine.Meter(buf, 1000)
+	assert.NoError(t, err)
+
+	mod, err := New().Compile(buf)
+	assert.NoError(t, err)
+	inst, err := mod.Instantiate(context.Background(), make(engine.Imports), nil)
+	assert.NoError(t, err)
+	defer inst.Close()
+
+	fuel, err := inst.Global(engine.FuelGlobal)
+	assert.NoError(t, err)
+	assert.Equal(t, engine.I64, fuel.Type())
+	assert.NoError(t, fuel.Set(int64(500)))
+
+	fn, err := inst.Function("lensvm_exec_loop")
+	assert.NoError(t, err)
+	_, err = fn.Call(context.Background(), int32(0), int32(0), int32(0), int32(0), int32(0))
+	assert.Error(t, err)
+
+	left, err := fuel.Get()
+	assert.NoError(t, err)
+	assert.True(t, left.(int64) < 0)
+}
+
+func TestCallTimeout(t *testing.T) {
+	assert.True(t, New().Supports(engine.FeatureInterrupt))
+	assert.True(t, New().Supports(engine.FeatureReadOnlyDirs))
+
+	buf, err := ioutil.ReadFile("../../testdata/loop/main.wasm")
+	assert.NoError(t, err)
+
+	mod, err := New().Compile(buf)
+	assert.NoError(t, err)
+	inst, err := mod.Instantiate(context.Background(), make(engine.Imports), nil)
+	assert.NoError(t, err)
+	defer inst.Close()
+
+	fn, err := inst.Function("lensvm_exec_loop")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = fn.Call(ctx, int32(0), int32(0), int32(0), int32(0), int32(0))
+	assert.Error(t, err)
+}
+
+func TestInstantiateWASIDisabled(t *testing.T) {
+	buf, err := ioutil.ReadFile("../../testdata/simple/main.wasm")
+	assert.NoError(t, err)
+
+	mod, err := New().Compile(buf)
+	assert.NoError(t, err)
+
+	// the simple module imports the WASI functions
+	imports := make(engine.Imports)
+	imports.Register("env", "lensvm_get_buffer", bufferFunc())
+	imports.Register("env", "lensvm_set_buffer", bufferFunc())
+	_, err = mod.Instantiate(context.Background(), imports, nil)
+	assert.Error(t, err)
+}
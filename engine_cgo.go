@@ -0,0 +1,13 @@
+//go:build cgo
+
+package lensvm
+
+import (
+	"github.com/lens-vm/lens-vm-go-host/engine"
+	"github.com/lens-vm/lens-vm-go-host/engine/wasmer"
+)
+
+// defaultEngine is wasmer, when cgo is enabled
+func defaultEngine(opt *Options) (engine.Engine, error) {
+	return wasmer.NewWithOptions(wasmer.Options{CacheDir: opt.ModuleCacheDir})
+}
@@ -0,0 +1,12 @@
+//go:build cgo
+
+package lensvm
+
+import (
+	"github.com/lens-vm/lens-vm-go-host/engine"
+	"github.com/lens-vm/lens-vm-go-host/engine/wasmer"
+)
+
+func init() {
+	testEngines["wasmer"] = func() engine.Engine { return wasmer.New() }
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package lensvm
+
+import (
+	"github.com/lens-vm/lens-vm-go-host/engine"
+	"github.com/lens-vm/lens-vm-go-host/engine/wazero"
+)
+
+// defaultEngine is wazero, when cgo is disabled
+func defaultEngine(opt *Options) (engine.Engine, error) {
+	return wazero.NewWithOptions(wazero.Options{CacheDir: opt.ModuleCacheDir})
+}
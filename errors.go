@@ -0,0 +1,143 @@
+package lensvm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	stypes "github.com/lens-vm/lens-vm-go-sdk/types"
+)
+
+var (
+	ErrMissingScheme     = errors.New("Resolve path is missing a URI scheme")
+	ErrMalformedPath     = errors.New("Malformed resolve path, must be of the form '<scheme>://<path>'")
+	ErrNoResolver        = errors.New("No resolver for given scheme")
+	ErrMissingLensFunc   = errors.New("Lens function is missing from module")
+	ErrMissingDependancy = errors.New("Missing module dependancy")
+	ErrMissingImportName = errors.New("Missing name of import function")
+	ErrMissingModuleID   = errors.New("Resolved module is missing an ID")
+	ErrMissingPackage    = errors.New("Missing module wasm bytes")
+	ErrLensNotImported   = errors.New("Lens function is not imported")
+	ErrInvalidLensEntry  = errors.New("Lens entry must define exactly one lens function")
+	ErrLensFailed        = errors.New("Lens function failed")
+	ErrExecSignature     = errors.New("Lens function has an invalid signature")
+	ErrInvalidPatch      = errors.New("Lens function wrote an invalid JSON merge patch")
+)
+
+// OptionsError is returned when a VM can't be
+// created with the given options
+type OptionsError struct {
+	// Option is the name of the invalid option, eg. Resolvers
+	Option string
+
+	Err error
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("Invalid %s option: %v", e.Option, e.Err)
+}
+
+func (e *OptionsError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveError is returned when a module file or
+// package can't be resolved, or isn't valid
+type ResolveError struct {
+	// URI that failed to resolve, eg. file://lens/module.json
+	URI string
+	// Scheme of the URI, empty if it's missing one
+	Scheme string
+
+	Err error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("Failed to resolve %s: %v", e.URI, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// resolveError wraps the error of resolving the given URI,
+// unless it's already the ResolveError of a dependancy
+func resolveError(uri string, err error) error {
+	var resErr *ResolveError
+	if errors.As(err, &resErr) {
+		return err
+	}
+
+	scheme := ""
+	if i := strings.Index(uri, "://"); i >= 0 {
+		scheme = uri[:i]
+	}
+	return &ResolveError{URI: uri, Scheme: scheme, Err: err}
+}
+
+// LinkError is returned when the imports of
+// a module can't be linked to their exports
+type LinkError struct {
+	// Module is the ID of the importing module
+	Module string
+	// Import is the name of the imported lens function,
+	// empty if the module itself failed to link
+	Import string
+
+	Err error
+}
+
+func (e *LinkError) Error() string {
+	if e.Import == "" {
+		return fmt.Sprintf("Failed to link module %s: %v", e.Module, e.Err)
+	}
+	return fmt.Sprintf("Failed to link import '%s' of module %s: %v", e.Import, e.Module, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// ExecError is returned when a lens function fails
+type ExecError struct {
+	// Lens is the name of the lens function, empty
+	// if the lens entry is invalid
+	Lens string
+	// Index of the lens entry in the LensFile,
+	// or -1 if called directly with ExecFunc
+	Index int
+	// GuestMessage is the message the lens function wrote
+	// into the output buffer when it failed, if any
+	GuestMessage string
+
+	Err error
+}
+
+func (e *ExecError) Error() string {
+	msg := "Lens"
+	if e.Lens != "" {
+		msg = fmt.Sprintf("Lens function '%s'", e.Lens)
+	}
+	if e.Index >= 0 {
+		msg += fmt.Sprintf(" at index %d", e.Index)
+	}
+	msg += fmt.Sprintf(" failed: %v", e.Err)
+	if e.GuestMessage != "" {
+		msg += ": " + e.GuestMessage
+	}
+	return msg
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// execError wraps the error of the lens function at the given
+// index, with the message the guest wrote if the lens failed
+func (vm *VM) execError(name string, index int, err error) error {
+	execErr := &ExecError{Lens: name, Index: index, Err: err}
+	if errors.Is(err, ErrLensFailed) {
+		execErr.GuestMessage = string(vm.GetBuffer(stypes.BufferTypeOutputPatch))
+	}
+	return execErr
+}
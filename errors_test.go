@@ -0,0 +1,114 @@
+package lensvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lens-vm/lens-vm-go-host/types"
+	stypes "github.com/lens-vm/lens-vm-go-sdk/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveErrorNoResolver(t *testing.T) {
+	vm := NewVM(nil)
+	_, err := vm.ResolveModule("ipfs://QmSomeHash")
+	assert.True(t, errors.Is(err, ErrNoResolver))
+
+	var resErr *ResolveError
+	if assert.True(t, errors.As(err, &resErr)) {
+		assert.Equal(t, "ipfs://QmSomeHash", resErr.URI)
+		assert.Equal(t, "ipfs", resErr.Scheme)
+	}
+}
+
+func TestResolveErrorMissingScheme(t *testing.T) {
+	vm := NewVM(nil)
+	_, err := vm.ResolveModule("testdata/simple/module.json")
+	assert.True(t, errors.Is(err, ErrMissingScheme))
+
+	var resErr *ResolveError
+	if assert.True(t, errors.As(err, &resErr)) {
+		assert.Equal(t, "", resErr.Scheme)
+	}
+}
+
+func TestResolveErrorInvalidModule(t *testing.T) {
+	vm := NewVM(nil)
+	_, err := vm.ResolveModule("file://testdata/invalid/module.json")
+	assert.True(t, errors.Is(err, ErrInvalidModuleFile))
+
+	var resErr *ResolveError
+	if assert.True(t, errors.As(err, &resErr)) {
+		assert.Equal(t, "file://testdata/invalid/module.json", resErr.URI)
+	}
+	var valErr *ValidationError
+	assert.True(t, errors.As(err, &valErr))
+}
+
+func TestLinkErrorMissingLensFunc(t *testing.T) {
+	vm := NewVM(nil)
+	_, err := vm.ImportModule("file://testdata/simple/module.json")
+	assert.NoError(t, err)
+
+	_, err = vm.ExecFunc(nil, nil, "missing", "file://testdata/simple/module.json")
+	assert.True(t, errors.Is(err, ErrMissingLensFunc))
+
+	var linkErr *LinkError
+	if assert.True(t, errors.As(err, &linkErr)) {
+		assert.Equal(t, "file://testdata/simple/module.json", linkErr.Module)
+		assert.Equal(t, "missing", linkErr.Import)
+	}
+}
+
+func TestLinkErrorInvalidModule(t *testing.T) {
+	vm := NewVM(nil)
+	_, err, _ := vm.addGlobalImport("rename", types.ResolvedModule{})
+	assert.True(t, errors.Is(err, ErrMissingModuleID))
+	var linkErr *LinkError
+	if assert.True(t, errors.As(err, &linkErr)) {
+		assert.Equal(t, "rename", linkErr.Import)
+	}
+
+	_, err, _ = vm.addGlobalImport("", types.ResolvedModule{ID: "file://rename.json"})
+	assert.True(t, errors.Is(err, ErrMissingImportName))
+	assert.True(t, errors.As(err, &linkErr))
+
+	_, err, _ = vm.addGlobalImport("rename", types.ResolvedModule{ID: "file://rename.json"})
+	assert.True(t, errors.Is(err, ErrMissingPackage))
+	var resErr *ResolveError
+	if assert.True(t, errors.As(err, &resErr)) {
+		assert.Equal(t, "file://rename.json", resErr.URI)
+	}
+}
+
+func TestValidationErrorArguments(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/invalid/lens.json"))
+
+	var valErr *ValidationError
+	if assert.True(t, errors.As(err, &valErr)) {
+		assert.Equal(t, "required", valErr.Rule)
+		assert.Equal(t, ErrInvalidArguments, valErr.Err)
+	}
+}
+
+func TestExecErrorGuestMessage(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeOutputPatch, []byte("missing field")))
+
+	err := vm.execError("rename", 2, ErrLensFailed)
+	assert.True(t, errors.Is(err, ErrLensFailed))
+	assert.Equal(t, "Lens function 'rename' at index 2 failed: Lens function failed: missing field", err.Error())
+
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "rename", execErr.Lens)
+		assert.Equal(t, 2, execErr.Index)
+		assert.Equal(t, "missing field", execErr.GuestMessage)
+	}
+
+	// only failed lenses have a guest message
+	err = vm.execError("rename", -1, ErrFuelExhausted)
+	assert.Equal(t, "Lens function 'rename' failed: fuel exhausted", err.Error())
+}
@@ -1,54 +1,39 @@
+// Command simple loads a lens file, and transforms a JSON document
+// with its lenses, eg. from the root of the repository:
+//
+//	go run ./examples/simple file://testdata/lens/simple/lens.json '{"name": "lens"}'
 package main
 
 import (
 	"fmt"
+	"os"
 
 	lensvm "github.com/lens-vm/lens-vm-go-host"
 )
 
-type ModuleFileDefinition struct {
-	Name        string            `json:"name"`
-	Description string            `json:"description,omitempty"`
-	Url         string            `json:"url,omitempty"`
-	Arguments   GenericDefinition `json:"arguments"`
-	Import      ImportDefinition  `json"import"`
-	Runtime     string            `json:"runtime"`
-	Language    string            `json:"language"`
-	Module      string            `json:"module"`
-
-	// Modules contains a list of ModuleFileDefinitions
-	// if this ModuleFile defines a collection of
-	// lens modules, and not just one.
-	// This is only used if the rest of the parameters are
-	// empty to ensure that this definition file
-	// is EITHER one module or a list of modules.
-	Modules []ModuleFileDefinition `json:"modules"`
-}
-
-type LensFileDefinition struct {
-	Import ImportDefinition    `json:"import"`
-	Lenses []GenericDefinition `json:"lenses"`
-}
-
-type ImportDefinition map[string]string
-type GenericDefinition map[string]interface{}
-
 func main() {
-	vm, err := lensvm.NewHostVM(lesnvm.HostOptions{})
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: simple <lens file uri> <json document>")
+		os.Exit(2)
+	}
+
+	vm, err := lensvm.NewVMWithError(nil)
 	if err != nil {
 		panic(err)
 	}
+	defer vm.Close()
 
-	// mod := h.NewModule([]byte("wasmBytes..."))
-	mod, err := vm.LoadModule(lensvm.NewFileLoader(""))
-
-	lens := lensvm.LensFileLoader(h, "lensfile.json")
-	lens := lensvm.LensBytesLoader(h, []byte("lensfile data"))
-	lens := vm.LoadLens(lensvm.LensLoader("https://"))
+	// resolve the lens file, and all the modules it imports
+	if err := vm.LoadLens(lensvm.LensFileLoader(os.Args[1])); err != nil {
+		panic(err)
+	}
+	if err := vm.Init(); err != nil {
+		panic(err)
+	}
 
-	out, err := vm.Exec("input document")
+	out, err := vm.Exec([]byte(os.Args[2]))
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Transformed document:", out)
+	fmt.Println("Transformed document:", string(out))
 }
@@ -1,13 +1,16 @@
 module github.com/lens-vm/lens-vm-go-host
 
-go 1.14
+go 1.19
 
 require (
+	github.com/Masterminds/semver v1.5.0
 	github.com/davecgh/go-spew v1.1.0
 	github.com/lens-vm/gogl v0.4.0
 	github.com/lens-vm/lens-vm-go-sdk v0.0.0-20210330121507-dd4f625c0bac
 	github.com/stretchr/testify v1.7.0
-	github.com/wasmerio/wasmer-go v1.0.3
+	github.com/tetratelabs/wazero v1.5.0
+	github.com/wasmerio/wasmer-go v1.0.4
+	github.com/xeipuuv/gojsonschema v1.2.0
 	gopkg.in/fatih/set.v0 v0.2.1 // indirect
 )
 
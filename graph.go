@@ -0,0 +1,155 @@
+package lensvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph is the resolved dependancy graph of a loaded lens, as
+// an adjacency list of modules and the lens functions they
+// import from each other.
+type Graph struct {
+	// Imports are the lens functions imported by the LensFile
+	Imports []GraphImport `json:"imports"`
+	// Modules are all the modules of the graph, sorted by ID
+	Modules []GraphModule `json:"modules"`
+}
+
+// GraphModule is a module in the dependancy graph
+type GraphModule struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name,omitempty"`
+	Version string        `json:"version,omitempty"`
+	Package string        `json:"package"`
+	Exports []string      `json:"exports"`
+	Imports []GraphImport `json:"imports"`
+}
+
+// GraphImport is an edge of the dependancy graph, from the
+// importing module to the module exporting the lens function
+type GraphImport struct {
+	// Name is the name of the imported lens function
+	Name string `json:"name"`
+	// Module is the ID of the imported module
+	Module string `json:"module"`
+}
+
+// DependancyGraph returns the dependancy graph of the loaded
+// lens, and any other imported modules.
+func (vm *VM) DependancyGraph() (*Graph, error) {
+	if err := vm.makeDependancyGraph(); err != nil {
+		return nil, err
+	}
+
+	g := &Graph{
+		Imports: []GraphImport{},
+		Modules: []GraphModule{},
+	}
+	for name := range vm.lensFile.Import {
+		if mod, ok := vm.lensImports[name]; ok {
+			g.Imports = append(g.Imports, GraphImport{Name: name, Module: mod.id})
+		}
+	}
+	sortGraphImports(g.Imports)
+
+	for id, mod := range vm.moduleImports {
+		def := mod.definition
+		gmod := GraphModule{
+			ID:      id,
+			Name:    def.Name,
+			Version: def.Version,
+			Package: def.PackagePath,
+			Exports: make([]string, len(def.Exports)),
+			Imports: []GraphImport{},
+		}
+		for i, export := range def.Exports {
+			gmod.Exports[i] = export.Name
+		}
+		// the dependancy graph has a single edge per imported
+		// module, so the imports come from the module itself
+		for name, dep := range mod.dependancies {
+			gmod.Imports = append(gmod.Imports, GraphImport{Name: name, Module: dep.id})
+		}
+		sortGraphImports(gmod.Imports)
+		g.Modules = append(g.Modules, gmod)
+	}
+	sort.Slice(g.Modules, func(i, j int) bool {
+		return g.Modules[i].ID < g.Modules[j].ID
+	})
+
+	return g, nil
+}
+
+func sortGraphImports(imports []GraphImport) {
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Name != imports[j].Name {
+			return imports[i].Name < imports[j].Name
+		}
+		return imports[i].Module < imports[j].Module
+	})
+}
+
+// JSON returns the graph as an indented JSON document
+func (g *Graph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. The
+// LensFile is the "lens" node, and every module is a node
+// labeled with its name, package and exports. The edges are
+// labeled with the names of the imported lens functions.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph lens {\n")
+	buf.WriteString("\tnode [shape=box];\n")
+	buf.WriteString("\t\"lens\" [shape=ellipse];\n")
+
+	for _, mod := range g.Modules {
+		label := mod.ID
+		if mod.Name != "" {
+			label = mod.Name
+			if mod.Version != "" {
+				label += "@" + mod.Version
+			}
+		}
+		label += "\n" + mod.Package
+		if len(mod.Exports) > 0 {
+			label += "\nexports: " + strings.Join(mod.Exports, ", ")
+		}
+		fmt.Fprintf(&buf, "\t%s [label=%s];\n", dotQuote(mod.ID), dotQuote(label))
+	}
+
+	for _, imp := range g.Imports {
+		fmt.Fprintf(&buf, "\t\"lens\" -> %s [label=%s];\n", dotQuote(imp.Module), dotQuote(imp.Name))
+	}
+	for _, mod := range g.Modules {
+		for _, imp := range mod.Imports {
+			fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n", dotQuote(mod.ID), dotQuote(imp.Module), dotQuote(imp.Name))
+		}
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// DOT returns the graph in the Graphviz DOT language,
+// see WriteDOT
+func (g *Graph) DOT() string {
+	var buf strings.Builder
+	g.WriteDOT(&buf)
+	return buf.String()
+}
+
+// dotQuote quotes the string as a DOT ID, with
+// newlines as centered line breaks
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
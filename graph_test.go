@@ -0,0 +1,118 @@
+package lensvm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependancyGraphResolveOnly(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.ResolveLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+	for _, mod := range vm.moduleImports {
+		assert.Nil(t, mod.wmod, mod.id)
+	}
+
+	g, err := vm.DependancyGraph()
+	assert.NoError(t, err)
+	assert.Len(t, g.Modules, 3)
+}
+
+func TestDependancyGraph(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+
+	g, err := vm.DependancyGraph()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []GraphImport{
+		{Name: "rename", Module: "file://testdata/importdeep/module.json"},
+	}, g.Imports)
+
+	assert.Len(t, g.Modules, 3)
+	copyMod := g.Modules[0]
+	assert.Equal(t, "file://testdata/importdeep/module.json", copyMod.ID)
+	assert.Equal(t, "copy", copyMod.Name)
+	assert.Equal(t, "file://testdata/simple/main.wasm", copyMod.Package)
+	assert.Equal(t, []string{"copy"}, copyMod.Exports)
+	assert.Equal(t, []GraphImport{
+		{Name: "extract", Module: "file://testdata/importsimple/module.json"},
+		{Name: "rename", Module: "file://testdata/simple/module.json"},
+	}, copyMod.Imports)
+
+	// the rename import of extract is linked to
+	// the module resolved by copy
+	assert.Equal(t, []GraphImport{
+		{Name: "rename", Module: "file://testdata/simple/module.json"},
+	}, g.Modules[1].Imports)
+	assert.Empty(t, g.Modules[2].Imports)
+}
+
+func TestDependancyGraphImportsSameModule(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importmulti/lens.json"))
+	assert.NoError(t, err)
+
+	g, err := vm.DependancyGraph()
+	assert.NoError(t, err)
+
+	// both lens functions imported from the
+	// same module are edges of the graph
+	assert.Len(t, g.Modules, 2)
+	assert.Equal(t, "file://testdata/importmulti/module.json", g.Modules[0].ID)
+	assert.Equal(t, []GraphImport{
+		{Name: "rename1", Module: "file://testdata/multi/module.json"},
+		{Name: "rename2", Module: "file://testdata/multi/module.json"},
+	}, g.Modules[0].Imports)
+	assert.Contains(t, g.DOT(), `"file://testdata/importmulti/module.json" -> "file://testdata/multi/module.json" [label="rename2"];`)
+}
+
+func TestDependancyGraphJSON(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+
+	g, err := vm.DependancyGraph()
+	assert.NoError(t, err)
+	buf, err := g.JSON()
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf, &doc))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "rename", "module": "file://testdata/simple/module.json"},
+	}, doc["imports"])
+
+	modules := doc["modules"].([]interface{})
+	assert.Len(t, modules, 1)
+	assert.Equal(t, map[string]interface{}{
+		"id":      "file://testdata/simple/module.json",
+		"name":    "rename",
+		"package": "file://testdata/simple/main.wasm",
+		"exports": []interface{}{"rename"},
+		"imports": []interface{}{},
+	}, modules[0])
+}
+
+func TestDependancyGraphDOT(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+
+	g, err := vm.DependancyGraph()
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph lens {
+	node [shape=box];
+	"lens" [shape=ellipse];
+	"file://testdata/simple/module.json" [label="rename\nfile://testdata/simple/main.wasm\nexports: rename"];
+	"lens" -> "file://testdata/simple/module.json" [label="rename"];
+}
+`, g.DOT())
+}
+
+func TestDotQuote(t *testing.T) {
+	assert.Equal(t, `"a \"b\" \\ c\nd"`, dotQuote("a \"b\" \\ c\nd"))
+}
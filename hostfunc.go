@@ -0,0 +1,304 @@
+package lensvm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+)
+
+// reservedPrefix is the prefix of the env functions which
+// are reserved for the host ABI, and linked lens functions
+const reservedPrefix = "lensvm_"
+
+var (
+	callContextType = reflect.TypeOf((*CallContext)(nil))
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// CallContext is the context of a host function call, which
+// a host function gets if its first param is a *CallContext.
+// It exposes the memory of the calling module instance, and
+// is only valid for the duration of the call.
+type CallContext struct {
+	ctx    context.Context
+	module *Module
+}
+
+// Context returns the context of the call
+func (c *CallContext) Context() context.Context {
+	return c.ctx
+}
+
+// ModuleID returns the ID of the calling module
+func (c *CallContext) ModuleID() string {
+	return c.module.id
+}
+
+// Memory returns the linear memory of the calling module,
+// or nil if it doesn't have one. The returned slice is
+// invalidated when the memory grows.
+func (c *CallContext) Memory() []byte {
+	if mem, ok := engine.Caller(c.ctx); ok {
+		return mem.Data()
+	}
+	if c.module.wmem != nil {
+		return c.module.wmem.Data()
+	}
+	return nil
+}
+
+// Read returns a copy of size bytes of the
+// memory of the calling module at ptr.
+func (c *CallContext) Read(ptr, size int32) ([]byte, error) {
+	return readMemory(c.Memory(), ptr, size)
+}
+
+// Write copies data into the memory
+// of the calling module at ptr.
+func (c *CallContext) Write(ptr int32, data []byte) error {
+	return writeMemory(c.Memory(), ptr, data)
+}
+
+// hostFunc is a Go function registered as a host function,
+// along with the wasm signature derived from its Go type.
+type hostFunc struct {
+	namespace string
+	name      string
+	fn        reflect.Value
+
+	params  []engine.ValueType
+	results []engine.ValueType
+
+	// withContext is set if the first param is a *CallContext,
+	// and withError if the last result is an error
+	withContext bool
+	withError   bool
+}
+
+// newHostFunc validates the Go function f, and derives its wasm
+// signature. The params and results may be int32, uint32, int64,
+// uint64, float32 and float64, along with an optional leading
+// *CallContext param, and an optional trailing error result.
+func newHostFunc(namespace, name string, f interface{}) (*hostFunc, error) {
+	if namespace == "" || name == "" || f == nil {
+		return nil, ErrInvalidParam
+	}
+
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func {
+		return nil, ErrRegisterNotFunc
+	}
+	if fn.IsNil() {
+		return nil, ErrInvalidParam
+	}
+
+	funcType := fn.Type()
+	if funcType.IsVariadic() {
+		return nil, ErrRegisterArgNum
+	}
+
+	h := &hostFunc{
+		namespace: namespace,
+		name:      name,
+		fn:        fn,
+	}
+
+	argsNum := funcType.NumIn()
+	first := 0
+	if argsNum > 0 && funcType.In(0) == callContextType {
+		h.withContext = true
+		first = 1
+	}
+	for i := first; i < argsNum; i++ {
+		kind, err := convertFromGoType(funcType.In(i))
+		if err != nil {
+			return nil, err
+		}
+		h.params = append(h.params, kind)
+	}
+
+	retsNum := funcType.NumOut()
+	if retsNum > 0 && funcType.Out(retsNum-1) == errorType {
+		h.withError = true
+		retsNum--
+	}
+	for i := 0; i < retsNum; i++ {
+		kind, err := convertFromGoType(funcType.Out(i))
+		if err != nil {
+			return nil, err
+		}
+		h.results = append(h.results, kind)
+	}
+
+	return h, nil
+}
+
+// bind returns the host function called by the given module. A
+// non nil error returned by the Go function, or a panic, traps
+// the guest, and is returned from the call into the guest.
+func (h *hostFunc) bind(mod *Module) engine.HostFunction {
+	funcType := h.fn.Type()
+	first := 0
+	if h.withContext {
+		first = 1
+	}
+
+	return engine.HostFunction{
+		Params:  h.params,
+		Results: h.results,
+		Func: func(ctx context.Context, args []interface{}) (callRes []interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					callRes = nil
+					err = fmt.Errorf("panic [%v] when calling func [%v]", r, h.name)
+				}
+			}()
+
+			if len(args) != len(h.params) {
+				return nil, fmt.Errorf("%w: func [%v] expects %d args, got %d", ErrInvalidParam, h.name, len(h.params), len(args))
+			}
+
+			in := make([]reflect.Value, 0, first+len(args))
+			if h.withContext {
+				in = append(in, reflect.ValueOf(&CallContext{ctx: ctx, module: mod}))
+			}
+			for i, arg := range args {
+				in = append(in, reflect.ValueOf(arg).Convert(funcType.In(first+i)))
+			}
+
+			out := h.fn.Call(in)
+			if h.withError {
+				if errVal := out[len(out)-1]; !errVal.IsNil() {
+					return nil, errVal.Interface().(error)
+				}
+				out = out[:len(out)-1]
+			}
+
+			ret := make([]interface{}, len(out))
+			for i, res := range out {
+				ret[i] = convertFromGoValue(res)
+			}
+			return ret, nil
+		},
+	}
+}
+
+// isReservedFunc reports if the host function name is
+// reserved, and can't be registered.
+func isReservedFunc(namespace, name string) bool {
+	return namespace == "env" && strings.HasPrefix(name, reservedPrefix)
+}
+
+// RegisterFunc registers the Go function f as a host function, which
+// the module imports with the given namespace and name. Functions
+// registered on the module take precedence over the ones registered
+// on the VM, see VM.RegisterFunc. It must be called before the
+// module is initialized.
+//
+// The params and results of f may be int32, uint32, int64, uint64,
+// float32 and float64. If the first param is a *CallContext, it
+// gets the memory of the calling module, and if the last result
+// is an error, a non nil error traps the guest.
+func (m *Module) RegisterFunc(namespace string, funcName string, f interface{}) error {
+	if m.initialized {
+		return ErrInstanceAlreadyStart
+	}
+	if isReservedFunc(namespace, funcName) {
+		return ErrRegisterReserved
+	}
+
+	h, err := newHostFunc(namespace, funcName, f)
+	if err != nil {
+		return err
+	}
+	m.hostFuncs = append(m.hostFuncs, h)
+	return nil
+}
+
+// RegisterFunc registers the Go function f as a host function, which
+// every module of the VM can import with the given namespace and name.
+// It must be called before the VM is initialized, see Module.RegisterFunc
+// for the supported function signatures.
+func (vm *VM) RegisterFunc(namespace string, funcName string, f interface{}) error {
+	if vm.initialized {
+		return ErrInstanceAlreadyStart
+	}
+	if isReservedFunc(namespace, funcName) {
+		return ErrRegisterReserved
+	}
+
+	h, err := newHostFunc(namespace, funcName, f)
+	if err != nil {
+		return err
+	}
+	vm.hostFuncs = append(vm.hostFuncs, h)
+	return nil
+}
+
+// registerHostFuncs adds the host ABI functions, and the host
+// functions registered on the VM and the module, to the imports
+// of the module.
+func (vm *VM) registerHostFuncs(mod *Module) error {
+	abi := []struct {
+		name string
+		f    interface{}
+	}{
+		{"lensvm_get_buffer", mod.lensVMGetBufferBytes},
+		{"lensvm_set_buffer", mod.lensVMSetBufferBytes},
+		{engine.GrowHook, mod.lensVMMemoryGrow},
+	}
+	for _, fn := range abi {
+		h, err := newHostFunc("env", fn.name, fn.f)
+		if err != nil {
+			return err
+		}
+		mod.imports.Register(h.namespace, h.name, h.bind(mod))
+	}
+
+	for _, h := range vm.hostFuncs {
+		mod.imports.Register(h.namespace, h.name, h.bind(mod))
+	}
+	for _, h := range mod.hostFuncs {
+		mod.imports.Register(h.namespace, h.name, h.bind(mod))
+	}
+	return nil
+}
+
+func convertFromGoType(t reflect.Type) (engine.ValueType, error) {
+	switch t.Kind() {
+	case reflect.Int32, reflect.Uint32:
+		return engine.I32, nil
+	case reflect.Int64, reflect.Uint64:
+		return engine.I64, nil
+	case reflect.Float32:
+		return engine.F32, nil
+	case reflect.Float64:
+		return engine.F64, nil
+	}
+
+	return 0, fmt.Errorf("%w: %v", ErrRegisterArgType, t)
+}
+
+// convertFromGoValue converts the Go value to the value of its
+// wasm type, unsigned ints keep their bits as signed ints.
+func convertFromGoValue(val reflect.Value) interface{} {
+	switch val.Kind() {
+	case reflect.Int32:
+		return int32(val.Int())
+	case reflect.Uint32:
+		return int32(uint32(val.Uint()))
+	case reflect.Int64:
+		return val.Int()
+	case reflect.Uint64:
+		return int64(val.Uint())
+	case reflect.Float32:
+		return float32(val.Float())
+	case reflect.Float64:
+		return val.Float()
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package lensvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMemory is a fixed size linear memory
+type testMemory []byte
+
+func (m testMemory) Data() []byte {
+	return m
+}
+
+func (m testMemory) Grow(pages uint32) bool {
+	return false
+}
+
+func TestNewHostFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       interface{}
+		params  []engine.ValueType
+		results []engine.ValueType
+		context bool
+		err     bool
+	}{
+		{name: "no args", f: func() {}},
+		{name: "many args", f: func(int32, int64, float32, float64) (int64, int32) { return 0, 0 },
+			params:  []engine.ValueType{engine.I32, engine.I64, engine.F32, engine.F64},
+			results: []engine.ValueType{engine.I64, engine.I32}},
+		{name: "unsigned", f: func(uint32, uint64) uint32 { return 0 },
+			params:  []engine.ValueType{engine.I32, engine.I64},
+			results: []engine.ValueType{engine.I32}},
+		{name: "error", f: func(int32) (int32, error) { return 0, nil },
+			params:  []engine.ValueType{engine.I32},
+			results: []engine.ValueType{engine.I32}},
+		{name: "context", f: func(*CallContext, int32) error { return nil },
+			params:  []engine.ValueType{engine.I32},
+			context: true},
+		{name: "string", f: func(string) {}, err: true},
+		{name: "int", f: func() int { return 0 }, err: true},
+		{name: "error not last", f: func() (error, int32) { return nil, 0 }, err: true},
+		{name: "context not first", f: func(int32, *CallContext) {}, err: true},
+		{name: "variadic", f: func(...int32) {}, err: true},
+	}
+
+	for _, test := range tests {
+		h, err := newHostFunc("env", "fn", test.f)
+		if test.err {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.params, h.params, test.name)
+		assert.Equal(t, test.results, h.results, test.name)
+		assert.Equal(t, test.context, h.withContext, test.name)
+	}
+}
+
+func TestNewHostFuncInvalid(t *testing.T) {
+	var nilFunc func()
+	_, err := newHostFunc("env", "fn", nilFunc)
+	assert.Equal(t, ErrInvalidParam, err)
+
+	_, err = newHostFunc("env", "fn", nil)
+	assert.Equal(t, ErrInvalidParam, err)
+
+	_, err = newHostFunc("", "fn", func() {})
+	assert.Equal(t, ErrInvalidParam, err)
+
+	_, err = newHostFunc("env", "fn", 42)
+	assert.Equal(t, ErrRegisterNotFunc, err)
+
+	_, err = newHostFunc("env", "fn", func(bool) {})
+	assert.True(t, errors.Is(err, ErrRegisterArgType))
+}
+
+func TestHostFuncCall(t *testing.T) {
+	h, err := newHostFunc("env", "add", func(a uint32, b uint64) (uint32, uint64) {
+		return a + 1, b + 1
+	})
+	assert.NoError(t, err)
+
+	res, err := h.bind(&Module{}).Func(context.Background(), []interface{}{int32(-2), int64(-2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(-1), int64(-1)}, res)
+
+	h, err = newHostFunc("env", "noop", func() {})
+	assert.NoError(t, err)
+	res, err = h.bind(&Module{}).Func(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestHostFuncCallError(t *testing.T) {
+	errFailed := errors.New("failed")
+	h, err := newHostFunc("env", "fail", func(fail int32) (int32, error) {
+		if fail != 0 {
+			return 0, errFailed
+		}
+		return 1, nil
+	})
+	assert.NoError(t, err)
+	fn := h.bind(&Module{})
+
+	res, err := fn.Func(context.Background(), []interface{}{int32(0)})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(1)}, res)
+
+	_, err = fn.Func(context.Background(), []interface{}{int32(1)})
+	assert.Equal(t, errFailed, err)
+
+	h, err = newHostFunc("env", "panic", func() { panic("oops") })
+	assert.NoError(t, err)
+	_, err = h.bind(&Module{}).Func(context.Background(), nil)
+	assert.Error(t, err)
+
+	_, err = fn.Func(context.Background(), nil)
+	assert.True(t, errors.Is(err, ErrInvalidParam))
+}
+
+func TestHostFuncCallContext(t *testing.T) {
+	h, err := newHostFunc("env", "upper", func(c *CallContext, ptr, size int32) error {
+		buf, err := c.Read(ptr, size)
+		if err != nil {
+			return err
+		}
+		for i := range buf {
+			buf[i] -= 'a' - 'A'
+		}
+		return c.Write(ptr, buf)
+	})
+	assert.NoError(t, err)
+
+	// the caller memory of the engine takes precedence
+	// over the memory of the module
+	mod := &Module{id: "upper", wmem: testMemory("xxxx")}
+	mem := testMemory("hello")
+	ctx := engine.WithCaller(context.Background(), mem)
+
+	fn := h.bind(mod)
+	_, err = fn.Func(ctx, []interface{}{int32(1), int32(3)})
+	assert.NoError(t, err)
+	assert.Equal(t, "hELLo", string(mem))
+	assert.Equal(t, "xxxx", string(mod.wmem.(testMemory)))
+
+	_, err = fn.Func(context.Background(), []interface{}{int32(0), int32(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, "XXxx", string(mod.wmem.(testMemory)))
+
+	_, err = fn.Func(ctx, []interface{}{int32(4), int32(2)})
+	assert.Equal(t, ErrAddrOverflow, err)
+
+	// without any memory, every address overflows
+	_, err = h.bind(&Module{}).Func(context.Background(), []interface{}{int32(0), int32(1)})
+	assert.Equal(t, ErrAddrOverflow, err)
+}
+
+func TestRegisterFunc(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NoError(t, vm.RegisterFunc("env", "double", func(v int32) int32 { return v * 2 }))
+	assert.Equal(t, ErrRegisterReserved, vm.RegisterFunc("env", "lensvm_get_buffer", func() {}))
+	assert.Equal(t, ErrRegisterNotFunc, vm.RegisterFunc("env", "double", "double"))
+
+	mod := &Module{vm: vm, id: "double"}
+	assert.NoError(t, mod.RegisterFunc("env", "double", func(v int32) int32 { return v * 3 }))
+	assert.Equal(t, ErrRegisterReserved, mod.RegisterFunc("env", "lensvm_exec_rename", func() {}))
+
+	// the module function takes precedence over the VM function
+	mod.imports = make(engine.Imports)
+	assert.NoError(t, vm.registerHostFuncs(mod))
+	assert.Contains(t, mod.imports["env"], "lensvm_get_buffer")
+	assert.Contains(t, mod.imports["env"], "lensvm_set_buffer")
+	res, err := mod.imports["env"]["double"].Func(context.Background(), []interface{}{int32(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(6)}, res)
+
+	mod.initialized = true
+	assert.Equal(t, ErrInstanceAlreadyStart, mod.RegisterFunc("env", "triple", func() {}))
+	vm.initialized = true
+	assert.Equal(t, ErrInstanceAlreadyStart, vm.RegisterFunc("env", "triple", func() {}))
+}
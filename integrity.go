@@ -0,0 +1,63 @@
+package lensvm
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lens-vm/lens-vm-go-host/internal/sri"
+)
+
+var (
+	ErrIntegrityMismatch  = errors.New("integrity mismatch")
+	ErrMalformedIntegrity = errors.New("malformed integrity")
+)
+
+// IntegrityError is returned when resolved content doesn't
+// match the integrity hash of its reference.
+type IntegrityError struct {
+	URI       string
+	Integrity string
+	Actual    string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("Integrity mismatch for %s, expected %s got %s", e.URI, e.Integrity, e.Actual)
+}
+
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrityMismatch
+}
+
+// Integrity returns the SRI style sha256 integrity hash of buf,
+// eg. sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+func Integrity(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyIntegrity checks the content resolved from uri against the
+// given SRI style integrity. The integrity can list multiple space
+// separated hashes, in which case the ones of the strongest supported
+// algorithm are checked, and any match is accepted. An empty integrity
+// always passes.
+func verifyIntegrity(uri, integrity string, buf []byte) error {
+	if strings.TrimSpace(integrity) == "" {
+		return nil
+	}
+
+	ok, err := sri.Verify(integrity, buf)
+	if err != nil {
+		return fmt.Errorf("%w for %s: %v", ErrMalformedIntegrity, uri, err)
+	}
+	if !ok {
+		return &IntegrityError{
+			URI:       uri,
+			Integrity: integrity,
+			Actual:    Integrity(buf),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package lensvm
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/lens-vm/lens-vm-go-host/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyIntegrity(t *testing.T) {
+	buf := []byte("lens module")
+
+	assert.NoError(t, verifyIntegrity("file://module.json", "", buf))
+	assert.NoError(t, verifyIntegrity("file://module.json", Integrity(buf), buf))
+	assert.NoError(t, verifyIntegrity("file://module.json", Integrity([]byte("other"))+" "+Integrity(buf), buf))
+
+	err := verifyIntegrity("file://module.json", Integrity([]byte("other")), buf)
+	assert.True(t, errors.Is(err, ErrIntegrityMismatch))
+
+	err = verifyIntegrity("file://module.json", "md5-abcd", buf)
+	assert.True(t, errors.Is(err, ErrMalformedIntegrity))
+}
+
+func TestReferenceJSON(t *testing.T) {
+	var imports types.ImportDefinition
+	err := json.Unmarshal([]byte(`{
+		"rename": "file://rename/module.json",
+		"copy": {"uri": "file://copy/module.json", "integrity": "sha256-abcd"}
+	}`), &imports)
+	assert.NoError(t, err)
+
+	assert.Equal(t, types.Reference{URI: "file://rename/module.json"}, imports["rename"])
+	assert.Equal(t, types.Reference{URI: "file://copy/module.json", Integrity: "sha256-abcd"}, imports["copy"])
+
+	buf, err := json.Marshal(imports["rename"])
+	assert.NoError(t, err)
+	assert.Equal(t, `"file://rename/module.json"`, string(buf))
+}
@@ -0,0 +1,43 @@
+// Package fsutil has the file helpers shared by the on disk caches
+package fsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempPrefix prefixes the temporary files used while writing
+// a file, which readers of the directory must skip
+const TempPrefix = ".tmp-"
+
+// WriteFileAtomic writes the file through a temporary file,
+// so concurrent readers never see a partial file. Like
+// ioutil.WriteFile, the file is readable by everyone.
+func WriteFileAtomic(path string, buf []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), TempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// IsTemp reports if the file name is
+// a temporary file of WriteFileAtomic
+func IsTemp(name string) bool {
+	return strings.HasPrefix(name, TempPrefix)
+}
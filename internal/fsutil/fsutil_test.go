@@ -0,0 +1,32 @@
+package fsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+
+	assert.NoError(t, WriteFileAtomic(path, []byte("first")))
+	assert.NoError(t, WriteFileAtomic(path, []byte("second")))
+	buf, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(buf))
+
+	// the temporary files are removed
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, os.FileMode(0644), files[0].Mode().Perm())
+
+	assert.True(t, IsTemp(TempPrefix+"123"))
+	assert.False(t, IsTemp("file"))
+
+	assert.Error(t, WriteFileAtomic(filepath.Join(dir, "missing", "file"), nil))
+}
@@ -0,0 +1,80 @@
+// Package sri checks content against subresource integrity style
+// hashes, eg. sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+package sri
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ErrNoSupportedHash is returned when none of the hashes
+// of an integrity uses a supported algorithm
+var ErrNoSupportedHash = errors.New("no hash with a supported algorithm")
+
+// algorithms are the supported algorithms, strongest first
+var algorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha512", sha512.New},
+	{"sha384", sha512.New384},
+	{"sha256", sha256.New},
+}
+
+// Verify reports if buf matches the integrity, a list of space
+// separated <algorithm>-<base64 digest>[?<options>] hashes. Like
+// browsers do, only the hashes of the strongest supported algorithm
+// are checked, and any of them matching is a match. The options,
+// and the hashes of unsupported algorithms, are ignored, and so are
+// malformed hashes, unless no other hash is left to check.
+func Verify(integrity string, buf []byte) (bool, error) {
+	digests := make(map[string][]string)
+	for _, token := range strings.Fields(integrity) {
+		if i := strings.IndexByte(token, '?'); i >= 0 {
+			token = token[:i]
+		}
+		if parts := strings.SplitN(token, "-", 2); len(parts) == 2 {
+			digests[parts[0]] = append(digests[parts[0]], parts[1])
+		}
+	}
+
+	var malformed error
+	for _, alg := range algorithms {
+		h := alg.new()
+		var expected [][]byte
+		for _, digest := range digests[alg.name] {
+			sum, err := base64.StdEncoding.DecodeString(digest)
+			if err != nil {
+				malformed = fmt.Errorf("malformed %s hash: %w", alg.name, err)
+				continue
+			}
+			if len(sum) != h.Size() {
+				malformed = fmt.Errorf("malformed %s hash: %d bytes long", alg.name, len(sum))
+				continue
+			}
+			expected = append(expected, sum)
+		}
+		if len(expected) == 0 {
+			continue
+		}
+
+		h.Write(buf)
+		sum := h.Sum(nil)
+		for _, e := range expected {
+			if subtle.ConstantTimeCompare(sum, e) == 1 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if malformed != nil {
+		return false, malformed
+	}
+	return false, ErrNoSupportedHash
+}
@@ -0,0 +1,56 @@
+package sri
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	buf := []byte("content")
+	sum256 := sha256.Sum256(buf)
+	sum512 := sha512.Sum512(buf)
+	other := sha512.Sum512([]byte("other"))
+	sha256Hash := "sha256-" + base64.StdEncoding.EncodeToString(sum256[:])
+	sha512Hash := "sha512-" + base64.StdEncoding.EncodeToString(sum512[:])
+	otherHash := "sha512-" + base64.StdEncoding.EncodeToString(other[:])
+
+	ok, err := Verify(sha512Hash, buf)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// any hash of the strongest algorithm matches, with options ignored
+	ok, err = Verify(otherHash+" "+sha512Hash+"?opt sha1-abcd", buf)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// weaker algorithms aren't checked when a stronger one is listed
+	ok, err = Verify(sha256Hash+" "+otherHash, buf)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = Verify("md5-abcd sha1-abcd", buf)
+	assert.True(t, errors.Is(err, ErrNoSupportedHash))
+
+	_, err = Verify("sha256-!!", buf)
+	assert.Error(t, err)
+	_, err = Verify("sha512-YWJjZA==", buf)
+	assert.Error(t, err)
+
+	// malformed hashes are skipped, when another one can be checked
+	ok, err = Verify("sha512-!! "+sha512Hash, buf)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify("sha512-!! "+otherHash, buf)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = Verify("sha512-!! "+sha256Hash, buf)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
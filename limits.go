@@ -0,0 +1,263 @@
+package lensvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+)
+
+var (
+	ErrFuelExhausted    = errors.New("fuel exhausted")
+	ErrExecTimeout      = errors.New("exec timeout")
+	ErrTimeoutNeedsFuel = errors.New("exec timeout requires fuel, the engine can't interrupt calls")
+	ErrMemoryLimit      = engine.ErrMemoryLimit
+	ErrUnsupported      = engine.ErrUnsupported
+)
+
+// limitContext returns the context bounded
+// by the exec timeout of the VM, if set
+func (vm *VM) limitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if vm.execTimeout > 0 {
+		return context.WithTimeout(ctx, vm.execTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// checkInterrupt fails with ErrTimeoutNeedsFuel if the context can
+// be done, but the engine can't interrupt calls, and there's no fuel
+// to stop them. The instances of an abandoned call can only be
+// replaced once it stopped, so the exec would never return.
+func (vm *VM) checkInterrupt(ctx context.Context) error {
+	if ctx.Done() != nil && vm.fuel == 0 && !vm.engine.Supports(engine.FeatureInterrupt) {
+		return ErrTimeoutNeedsFuel
+	}
+	return nil
+}
+
+// limitError wraps the error of a guest call
+// with the reason the call was interrupted, if any
+func (vm *VM) limitError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case nil:
+		return err
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", ErrExecTimeout, err)
+	default:
+		if errors.Is(err, ctx.Err()) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+}
+
+// isInterrupted reports if the error interrupted a guest call,
+// which leaves the module instances in an unusable state
+func isInterrupted(err error) bool {
+	return errors.Is(err, ErrExecTimeout) ||
+		errors.Is(err, ErrFuelExhausted) ||
+		errors.Is(err, ErrMemoryLimit) ||
+		errors.Is(err, context.Canceled)
+}
+
+// recoverInstances replaces the module instances
+// of the VM, after an interrupted guest call
+func (vm *VM) recoverInstances() {
+	vm.waitCalls()
+	vm.closeInstances()
+	if vm.initialized {
+		vm.initialized = false
+		if err := vm.Init(); err != nil {
+			vm.closeInstances()
+		}
+	}
+}
+
+// waitCalls waits until the guest calls abandoned by an interrupted
+// exec have returned, if the engine can't interrupt calls. Until then
+// their host functions still use the modules and buffers of the VM.
+// Importers are waited on first, as their calls may call into the
+// modules they import.
+func (vm *VM) waitCalls() {
+	ids := sortedKeys(vm.moduleImports)
+	if vm.dgraph != nil {
+		if order, err := vm.dgraph.SortOrder(ids...); err == nil {
+			ids = order
+		}
+	}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		if w, ok := vm.moduleImports[ids[i]].winst.(engine.Waiter); ok {
+			w.Wait()
+		}
+	}
+}
+
+// execLimited runs the lens function within the limits of the VM,
+// with the given remaining fuel of the exec call, and returns the
+// fuel left afterwards.
+func (mod *Module) execLimited(ctx context.Context, name string, args, input []byte, fuel uint64) ([]byte, uint64, error) {
+	vm := mod.vm
+	vm.memoryDenied = false
+	vm.hostErr = nil
+	vm.resetScratch()
+	if vm.fuel > 0 {
+		vm.setFuel(fuel)
+	}
+
+	out, err := mod.exec(ctx, name, args, input)
+	if err != nil && ctx.Err() != nil {
+		// the call may still be running, if
+		// the engine can't interrupt calls
+		return nil, 0, vm.limitError(ctx, err)
+	}
+	if vm.fuel > 0 {
+		var exhausted bool
+		fuel, exhausted = vm.remainingFuel(fuel)
+		if exhausted {
+			return nil, 0, fmt.Errorf("%w: lens function '%s' ran out of fuel", ErrFuelExhausted, name)
+		}
+	}
+	if err != nil {
+		if vm.memoryDenied {
+			return nil, 0, fmt.Errorf("%w: lens function '%s' failed: %v", ErrMemoryLimit, name, err)
+		}
+		return nil, 0, vm.limitError(ctx, err)
+	}
+	return out, fuel, nil
+}
+
+// memoryBudget is the budget of linear memory pages, shared by
+// the module instances of a VM and of its pool workers. A nil
+// budget is unlimited.
+type memoryBudget struct {
+	mu    sync.Mutex
+	limit uint32
+	used  uint32
+}
+
+func newMemoryBudget(limit uint32) *memoryBudget {
+	if limit == 0 {
+		return nil
+	}
+	return &memoryBudget{limit: limit}
+}
+
+// reserve reserves the given pages, and
+// reports if they fit into the budget
+func (b *memoryBudget) reserve(pages uint32) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pages > b.limit-b.used {
+		return false
+	}
+	b.used += pages
+	return true
+}
+
+// release returns the given reserved pages to the budget
+func (b *memoryBudget) release(pages uint32) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= pages
+}
+
+// reserveMemory reserves the pages of the memory of the new instance
+// of the module, besides the pages its start function already grew.
+func (mod *Module) reserveMemory() error {
+	size := uint32(len(mod.wmem.Data()) / wasmPageSize)
+	reserved := atomic.LoadUint32(&mod.pages)
+	if size <= reserved {
+		return nil
+	}
+
+	budget := mod.vm.budget
+	if !budget.reserve(size - reserved) {
+		return fmt.Errorf("%w: module %s needs %d pages of memory, over the budget of %d", ErrMemoryLimit, mod.id, size, budget.limit)
+	}
+	atomic.AddUint32(&mod.pages, size-reserved)
+	return nil
+}
+
+// releaseMemory releases the pages reserved by
+// the instance of the module, once it's closed
+func (mod *Module) releaseMemory() {
+	mod.vm.budget.release(atomic.SwapUint32(&mod.pages, 0))
+}
+
+// lensVMMemoryGrow is the engine.GrowHook of the module, which
+// replaces memory.grow. It only grows the memory of the instance
+// if the pages fit into the memory budget, and the maximum memory
+// size. Otherwise, it returns -1 like memory.grow, and the exec
+// call fails with ErrMemoryLimit, once the guest gives up.
+func (mod *Module) lensVMMemoryGrow(c *CallContext, pages uint32) int32 {
+	mem, ok := engine.Caller(c.ctx)
+	if !ok {
+		mem = mod.wmem
+	}
+	if mem == nil {
+		return -1
+	}
+
+	prev := int32(len(mem.Data()) / wasmPageSize)
+	if pages == 0 {
+		return prev
+	}
+	if !mod.vm.budget.reserve(pages) {
+		mod.vm.memoryDenied = true
+		return -1
+	}
+	if !mem.Grow(pages) {
+		mod.vm.budget.release(pages)
+		mod.vm.memoryDenied = true
+		return -1
+	}
+	atomic.AddUint32(&mod.pages, pages)
+	return prev
+}
+
+// setFuel sets the remaining fuel of every module instance.
+// A lens call can call into many instances, so each of them
+// gets the whole remaining fuel.
+func (vm *VM) setFuel(fuel uint64) {
+	for _, mod := range vm.moduleImports {
+		if mod.wfuel != nil {
+			mod.wfuel.Set(int64(fuel))
+		}
+	}
+}
+
+// remainingFuel returns the fuel left after a lens call, which is
+// the given fuel, less the fuel consumed by all of the instances.
+// It reports if the call ran out of fuel.
+func (vm *VM) remainingFuel(fuel uint64) (uint64, bool) {
+	var used uint64
+	for _, mod := range vm.moduleImports {
+		if mod.wfuel == nil {
+			continue
+		}
+
+		v, err := mod.wfuel.Get()
+		left, ok := v.(int64)
+		if err != nil || !ok || left < 0 {
+			return 0, true
+		}
+		used += fuel - uint64(left)
+	}
+
+	if used > fuel {
+		return 0, true
+	}
+	return fuel - used, false
+}
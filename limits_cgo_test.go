@@ -0,0 +1,86 @@
+//go:build cgo
+
+package lensvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lens-vm/lens-vm-go-host/engine/wasmer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wasmer can't interrupt calls, so the calls abandoned by a
+// timeout keep setting the output buffer until their fuel
+// runs out, while the instances are replaced
+func TestExecTimeoutWasmer(t *testing.T) {
+	vm, err := NewVMWithError(&Options{
+		Resolvers:   DefaultOptions.Resolvers,
+		Engine:      wasmer.New(),
+		ExecTimeout: 10 * time.Millisecond,
+		Fuel:        1000000,
+	})
+	require.NoError(t, err)
+	defer vm.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err = vm.ExecFunc(nil, nil, "busy", "file://testdata/busy/module.json")
+		assert.True(t, errors.Is(err, ErrExecTimeout), err)
+	}
+
+	out, err := vm.ExecFunc([]byte(`{"body": "bye"}`), []byte(`{"source": "body", "destination": "title"}`), "rename", "file://testdata/simple/module.json")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"body": "bye", "title": "bye"}`, string(out))
+}
+
+func TestExecTimeoutWasmerPool(t *testing.T) {
+	vm, err := NewVMWithError(&Options{
+		Resolvers:   DefaultOptions.Resolvers,
+		Engine:      wasmer.New(),
+		ExecTimeout: 10 * time.Millisecond,
+		Fuel:        1000000,
+		PoolSize:    1,
+	})
+	require.NoError(t, err)
+	defer vm.Close()
+	require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/busy/lens.json")))
+	require.NoError(t, vm.Init())
+
+	// the timed out worker is replaced once its call stopped
+	for i := 0; i < 2; i++ {
+		_, err = vm.Exec([]byte(`{}`))
+		assert.True(t, errors.Is(err, ErrExecTimeout), err)
+	}
+}
+
+// without fuel, an exec with a context which can be done is
+// rejected, instead of waiting on a call which never stops
+func TestExecContextNeedsFuelWasmer(t *testing.T) {
+	vm, err := NewVMWithError(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		Engine:    wasmer.New(),
+	})
+	require.NoError(t, err)
+	defer vm.Close()
+	require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/busy/lens.json")))
+	require.NoError(t, vm.Init())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := vm.ExecContext(ctx, []byte(`{}`))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrTimeoutNeedsFuel), err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecContext didn't return")
+	}
+}
@@ -0,0 +1,262 @@
+package lensvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+	"github.com/lens-vm/lens-vm-go-host/engine/wazero"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGlobal is a fake fuel global
+type fakeGlobal struct {
+	fuel int64
+}
+
+func (g *fakeGlobal) Type() engine.ValueType {
+	return engine.I64
+}
+
+func (g *fakeGlobal) Get() (interface{}, error) {
+	return g.fuel, nil
+}
+
+func (g *fakeGlobal) Set(v interface{}) error {
+	g.fuel = v.(int64)
+	return nil
+}
+
+func TestRemainingFuel(t *testing.T) {
+	a, b := &fakeGlobal{}, &fakeGlobal{}
+	vm := &VM{
+		moduleImports: map[string]*Module{
+			"a": {wfuel: a},
+			"b": {wfuel: b},
+		},
+	}
+
+	vm.setFuel(100)
+	assert.Equal(t, int64(100), a.fuel)
+	assert.Equal(t, int64(100), b.fuel)
+
+	a.fuel, b.fuel = 70, 90
+	fuel, exhausted := vm.remainingFuel(100)
+	assert.False(t, exhausted)
+	assert.Equal(t, uint64(60), fuel)
+
+	a.fuel, b.fuel = 0, 0
+	_, exhausted = vm.remainingFuel(100)
+	assert.True(t, exhausted)
+
+	a.fuel = -1
+	_, exhausted = vm.remainingFuel(100)
+	assert.True(t, exhausted)
+}
+
+func TestLimitError(t *testing.T) {
+	vm := &VM{}
+	err := errors.New("trap")
+	assert.Equal(t, err, vm.limitError(context.Background(), err))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	assert.True(t, errors.Is(vm.limitError(ctx, err), ErrExecTimeout))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, errors.Is(vm.limitError(ctx, err), context.Canceled))
+	assert.True(t, isInterrupted(vm.limitError(ctx, err)))
+}
+
+func TestExecTimeout(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers:   DefaultOptions.Resolvers,
+		Engine:      wazero.New(),
+		ExecTimeout: 50 * time.Millisecond,
+	})
+
+	start := time.Now()
+	_, err := vm.ExecFunc(nil, nil, "loop", "file://testdata/loop/module.json")
+	assert.True(t, errors.Is(err, ErrExecTimeout), err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	// the interrupted instance is replaced
+	_, err = vm.ExecFunc(nil, nil, "loop", "file://testdata/loop/module.json")
+	assert.True(t, errors.Is(err, ErrExecTimeout), err)
+}
+
+func TestExecFuel(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		Engine:    wazero.New(),
+		Fuel:      1000,
+	})
+
+	_, err := vm.ExecFunc(nil, nil, "loop", "file://testdata/loop/module.json")
+	assert.True(t, errors.Is(err, ErrFuelExhausted), err)
+}
+
+// featurelessEngine is an engine which supports none of
+// the optional features, eg. can't interrupt calls, like wasmer
+type featurelessEngine struct {
+	engine.Engine
+}
+
+func (e featurelessEngine) Supports(feature engine.Feature) bool {
+	return false
+}
+
+func TestExecTimeoutNeedsFuel(t *testing.T) {
+	_, err := NewVMWithError(&Options{
+		Resolvers:   DefaultOptions.Resolvers,
+		Engine:      featurelessEngine{wazero.New()},
+		ExecTimeout: time.Second,
+	})
+	assert.True(t, errors.Is(err, ErrTimeoutNeedsFuel), err)
+
+	// the fuel stops the call, before the timeout
+	vm, err := NewVMWithError(&Options{
+		Resolvers:   DefaultOptions.Resolvers,
+		Engine:      featurelessEngine{wazero.New()},
+		ExecTimeout: time.Minute,
+		Fuel:        1000,
+	})
+	assert.NoError(t, err)
+	_, err = vm.ExecFunc(nil, nil, "loop", "file://testdata/loop/module.json")
+	assert.True(t, errors.Is(err, ErrFuelExhausted), err)
+}
+
+// fakeMemory is a fake linear memory of the given size
+type fakeMemory struct {
+	data []byte
+}
+
+func (m *fakeMemory) Data() []byte {
+	return m.data
+}
+
+func (m *fakeMemory) Grow(pages uint32) bool {
+	m.data = append(m.data, make([]byte, int(pages)*wasmPageSize)...)
+	return true
+}
+
+func TestMemoryBudgetReserve(t *testing.T) {
+	b := newMemoryBudget(6)
+	assert.True(t, b.reserve(4))
+	assert.True(t, b.reserve(2))
+	assert.False(t, b.reserve(1))
+	b.release(3)
+	assert.True(t, b.reserve(3))
+	assert.False(t, b.reserve(1))
+
+	// without a budget, every reservation fits
+	b = newMemoryBudget(0)
+	assert.Nil(t, b)
+	assert.True(t, b.reserve(1<<20))
+}
+
+func TestMemoryGrowHook(t *testing.T) {
+	vm := &VM{budget: newMemoryBudget(3)}
+	mod := &Module{vm: vm, id: "a", wmem: &fakeMemory{make([]byte, wasmPageSize)}}
+	assert.NoError(t, mod.reserveMemory())
+
+	c := &CallContext{ctx: context.Background(), module: mod}
+	assert.Equal(t, int32(1), mod.lensVMMemoryGrow(c, 1))
+	assert.Equal(t, int32(2), mod.lensVMMemoryGrow(c, 0))
+	assert.False(t, vm.memoryDenied)
+	assert.Equal(t, int32(-1), mod.lensVMMemoryGrow(c, 2))
+	assert.True(t, vm.memoryDenied)
+	assert.Equal(t, uint32(2), mod.pages)
+
+	// the pages of the closed instance are released
+	mod.closeInstance()
+	assert.Equal(t, uint32(0), vm.budget.used)
+}
+
+func TestMaxMemoryPages(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers:      DefaultOptions.Resolvers,
+		MaxMemoryPages: 1,
+	})
+
+	// the simple module requires 2 pages of memory
+	_, err := vm.ImportModule("file://testdata/simple/module.json")
+	assert.True(t, errors.Is(err, ErrMemoryLimit), err)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers:         DefaultOptions.Resolvers,
+		Engine:            wazero.New(),
+		MemoryBudgetPages: 4,
+	})
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+
+	// every module instance needs 2 pages of memory
+	err = vm.Init()
+	assert.True(t, errors.Is(err, ErrMemoryLimit), err)
+
+	// the hooked memory growth of the guest runtime fits
+	vm = NewVM(&Options{
+		Resolvers:         DefaultOptions.Resolvers,
+		MemoryBudgetPages: 64,
+	})
+	err = vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, vm.Init())
+	out, err := vm.Exec([]byte(`{"body":"hello"}`))
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `"description":"hello"`)
+}
+
+func TestMemoryBudgetGrow(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers:         DefaultOptions.Resolvers,
+		MemoryBudgetPages: 3,
+	})
+
+	// the grow module starts with 1 page of memory,
+	// and grows it by 1 page on every call
+	_, err := vm.ExecFunc(nil, nil, "grow", "file://testdata/grow/module.json")
+	assert.NoError(t, err)
+	_, err = vm.ExecFunc(nil, nil, "grow", "file://testdata/grow/module.json")
+	assert.NoError(t, err)
+	_, err = vm.ExecFunc(nil, nil, "grow", "file://testdata/grow/module.json")
+	assert.True(t, errors.Is(err, ErrMemoryLimit), err)
+
+	// the replaced instance starts over
+	_, err = vm.ExecFunc(nil, nil, "grow", "file://testdata/grow/module.json")
+	assert.NoError(t, err)
+}
+
+func TestMemoryBudgetPool(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers:         DefaultOptions.Resolvers,
+		PoolSize:          2,
+		MemoryBudgetPages: 3,
+	})
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/grow/lens.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, vm.Init())
+	defer vm.Close()
+
+	// only the worker instantiates the module, which takes
+	// 1 page, so the worker can only grow its memory twice
+	ctx := context.Background()
+	_, err = vm.ExecContext(ctx, []byte(`{}`))
+	assert.NoError(t, err)
+	_, err = vm.ExecContext(ctx, []byte(`{}`))
+	assert.NoError(t, err)
+	_, err = vm.ExecContext(ctx, []byte(`{}`))
+	assert.True(t, errors.Is(err, ErrMemoryLimit), err)
+
+	// the pages of the discarded worker are released
+	_, err = vm.ExecContext(ctx, []byte(`{}`))
+	assert.NoError(t, err)
+}
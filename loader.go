@@ -15,6 +15,11 @@ type LensLoader interface {
 	Path() string
 }
 
+type LockLoader interface {
+	Load(context.Context) (types.LockFile, error)
+	Path() string
+}
+
 type ModuleLoader interface {
 	Load(context.Context) (types.ModuleFile, error)
 	Path() string
@@ -55,6 +60,9 @@ func (l lensFileLoader) Load(ctx context.Context) (types.LensFile, error) {
 	if err != nil {
 		return lf, err
 	}
+	if err := ValidateLensFile(buf); err != nil {
+		return lf, err
+	}
 
 	err = json.Unmarshal(buf, &lf)
 	return lf, err
@@ -78,7 +86,34 @@ func (l moduleFileLoader) Load(ctx context.Context) (types.ModuleFile, error) {
 	if err != nil {
 		return mf, err
 	}
+	if err := ValidateModuleFile(buf); err != nil {
+		return mf, err
+	}
 
 	err = json.Unmarshal(buf, &mf)
 	return mf, err
 }
+
+type lockFileLoader struct {
+	genericLoader
+}
+
+func LockFileLoader(path string) LockLoader {
+	return lockFileLoader{
+		genericLoader{
+			resolver: file.FileResolver{},
+			path:     path,
+		},
+	}
+}
+
+func (l lockFileLoader) Load(ctx context.Context) (types.LockFile, error) {
+	var lf types.LockFile
+	buf, err := l.resolve(ctx)
+	if err != nil {
+		return lf, err
+	}
+
+	err = json.Unmarshal(buf, &lf)
+	return lf, err
+}
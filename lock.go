@@ -0,0 +1,122 @@
+package lensvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lens-vm/lens-vm-go-host/internal/fsutil"
+	"github.com/lens-vm/lens-vm-go-host/types"
+)
+
+var ErrLockFileOutdated = errors.New("lock file is out of date")
+
+// LockFile returns the lock file for the currently loaded lens,
+// pinning the ID, version, concrete URIs and content hashes of
+// every module in its import tree.
+func (vm *VM) LockFile() (types.LockFile, error) {
+	lock := types.LockFile{
+		LockFileVersion: types.LockFileVersion,
+		Import:          make(map[string]string),
+		Modules:         make(map[string]types.LockedModule),
+	}
+
+	for name := range vm.lensFile.Import {
+		mod, ok := vm.lensImports[name]
+		if !ok {
+			return types.LockFile{}, fmt.Errorf("Lens import '%s' has not been resolved", name)
+		}
+		lock.Import[name] = mod.id
+	}
+
+	for id, mod := range vm.moduleImports {
+		def := mod.definition
+		locked := types.LockedModule{
+			Version:   def.Version,
+			Integrity: def.Integrity,
+			Package: types.Reference{
+				URI:       def.PackagePath,
+				Integrity: Integrity(def.PackageBytes),
+			},
+		}
+		if def.ResolvedPath != id {
+			locked.Resolved = def.ResolvedPath
+		}
+		if def.PackageResolvedPath != def.PackagePath {
+			locked.PackageResolved = def.PackageResolvedPath
+		}
+		if len(def.Imports) > 0 {
+			locked.Imports = make(map[string]string)
+			for name, imp := range def.Imports {
+				locked.Imports[name] = imp.Path
+			}
+		}
+		lock.Modules[id] = locked
+	}
+
+	return lock, nil
+}
+
+// WriteLockFile writes the lock file for the currently loaded lens
+// to the given path, atomically, so readers never see a partial
+// lock file.
+func (vm *VM) WriteLockFile(path string) error {
+	lock, err := vm.LockFile()
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(lock, "", "    ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, buf)
+}
+
+// LoadLockedLens loads the lens like LoadLens, but resolves its
+// import tree from the given lock file instead. Module files and
+// packages are loaded from the concrete URIs recorded in the lock
+// file, so version ranges and tags aren't resolved again, and must
+// match the hashes recorded in the lock file.
+func (vm *VM) LoadLockedLens(l LensLoader, lock types.LockFile) error {
+	if lock.LockFileVersion != types.LockFileVersion {
+		return fmt.Errorf("Unsupported lock file version %d", lock.LockFileVersion)
+	}
+
+	ctx := context.TODO()
+	lens, err := l.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name, ref := range lens.Import {
+		if id, ok := lock.Import[name]; !ok || id != ref.URI {
+			return fmt.Errorf("%w: lens import '%s' is not locked to %s", ErrLockFileOutdated, name, ref.URI)
+		}
+	}
+
+	vm.lensFile = lens
+	vm.lock = &lock
+	if err := vm.resolveLens(ctx, vm.lensFile); err != nil {
+		return err
+	}
+	if err := vm.compileLensImports(); err != nil {
+		return err
+	}
+	return vm.validateLensArguments()
+}
+
+// lockedModule returns the lock file entry for the module with
+// the given ID, if the VM is loading from a lock file.
+func (vm *VM) lockedModule(id string) (types.LockedModule, bool, error) {
+	if vm.lock == nil {
+		return types.LockedModule{}, false, nil
+	}
+
+	locked, ok := vm.lock.Modules[id]
+	if !ok {
+		return types.LockedModule{}, false, fmt.Errorf("%w: module %s is not locked", ErrLockFileOutdated, id)
+	}
+	return locked, true, nil
+}
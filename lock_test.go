@@ -0,0 +1,158 @@
+package lensvm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lens-vm/lens-vm-go-host/resolvers"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/file"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFileDeepLens(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+
+	lock, err := vm.LockFile()
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"rename": "file://testdata/importdeep/module.json"}, lock.Import)
+	assert.Len(t, lock.Modules, 3)
+
+	deep := lock.Modules["file://testdata/importdeep/module.json"]
+	assert.Equal(t, "file://testdata/importsimple/module.json", deep.Imports["extract"])
+	assert.Equal(t, "file://testdata/simple/main.wasm", deep.Package.URI)
+
+	buf, err := ioutil.ReadFile("testdata/simple/main.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, Integrity(buf), deep.Package.Integrity)
+}
+
+func TestLoadLockedLens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lensvm-lock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lens.lock")
+
+	vm := NewVM(nil)
+	err = vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, vm.WriteLockFile(path))
+
+	lock, err := LockFileLoader("file://" + path).Load(context.Background())
+	assert.NoError(t, err)
+
+	vm = NewVM(nil)
+	err = vm.LoadLockedLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"), lock)
+	assert.NoError(t, err)
+	assert.Len(t, vm.moduleImports, 3)
+	assert.Len(t, vm.lensImports, 1)
+}
+
+func TestLoadLockedLensMismatch(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	assert.NoError(t, err)
+
+	lock, err := vm.LockFile()
+	assert.NoError(t, err)
+
+	simple := lock.Modules["file://testdata/simple/module.json"]
+	simple.Package.Integrity = Integrity([]byte("tampered"))
+	lock.Modules["file://testdata/simple/module.json"] = simple
+
+	vm = NewVM(nil)
+	err = vm.LoadLockedLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"), lock)
+	assert.True(t, errors.Is(err, ErrIntegrityMismatch))
+
+	delete(lock.Modules, "file://testdata/simple/module.json")
+	vm = NewVM(nil)
+	err = vm.LoadLockedLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"), lock)
+	assert.True(t, errors.Is(err, ErrLockFileOutdated))
+
+	vm = NewVM(nil)
+	err = vm.LoadLockedLens(LensFileLoader("file://testdata/lens/simple/lens.json"), lock)
+	assert.True(t, errors.Is(err, ErrLockFileOutdated))
+}
+
+// registryResolver resolves the latest tag of the reg
+// scheme to the current latest version of the registry
+type registryResolver struct {
+	latest   string
+	content  map[string][]byte
+	resolved []string
+}
+
+func (r *registryResolver) Scheme() string {
+	return "reg"
+}
+
+func (r *registryResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	buf, _, err := r.ResolveConcrete(ctx, target)
+	return buf, err
+}
+
+func (r *registryResolver) ResolveConcrete(ctx context.Context, target string) ([]byte, string, error) {
+	r.resolved = append(r.resolved, target)
+	concrete := strings.Replace(target, "@latest", "@"+r.latest, 1)
+	buf, ok := r.content[concrete]
+	if !ok {
+		return nil, "", errors.New("not found")
+	}
+	return buf, concrete, nil
+}
+
+func TestLoadLockedLensConcrete(t *testing.T) {
+	modFile, err := ioutil.ReadFile("testdata/simple/module.json")
+	require.NoError(t, err)
+	wasm, err := ioutil.ReadFile("testdata/simple/main.wasm")
+	require.NoError(t, err)
+
+	modFile = []byte(strings.Replace(string(modFile), "file://testdata/simple/main.wasm", "reg://rename@latest/main.wasm", 1))
+	reg := &registryResolver{latest: "1", content: map[string][]byte{
+		"rename@1/module.json": modFile,
+		"rename@1/main.wasm":   wasm,
+		"rename@2/module.json": []byte(strings.Replace(string(modFile), "Rename a field", "Rename a field, v2", 1)),
+		"rename@2/main.wasm":   wasm,
+	}}
+	opts := &Options{Resolvers: []resolvers.Resolver{file.FileResolver{}, reg}}
+
+	dir := t.TempDir()
+	lens := filepath.Join(dir, "lens.json")
+	err = ioutil.WriteFile(lens, []byte(`{
+		"import": {"rename": "reg://rename@latest/module.json"},
+		"lenses": [{"rename": {"source": "body", "destination": "description"}}]
+	}`), 0644)
+	require.NoError(t, err)
+
+	vm := NewVM(opts)
+	require.NoError(t, vm.LoadLens(LensFileLoader("file://"+lens)))
+	lock, err := vm.LockFile()
+	require.NoError(t, err)
+
+	locked := lock.Modules["reg://rename@latest/module.json"]
+	assert.Equal(t, "reg://rename@1/module.json", locked.Resolved)
+	assert.Equal(t, "reg://rename@latest/main.wasm", locked.Package.URI)
+	assert.Equal(t, "reg://rename@1/main.wasm", locked.PackageResolved)
+
+	// a new latest version doesn't change the locked lens,
+	// which only resolves the concrete URIs of the lock file
+	reg.latest = "2"
+	reg.resolved = nil
+	vm = NewVM(opts)
+	require.NoError(t, vm.LoadLockedLens(LensFileLoader("file://"+lens), lock))
+	assert.Equal(t, []string{"rename@1/module.json", "rename@1/main.wasm"}, reg.resolved)
+	mod := vm.moduleImports["reg://rename@latest/module.json"]
+	require.NotNil(t, mod)
+	assert.Equal(t, "Rename a field from source to destination", mod.definition.Description)
+}
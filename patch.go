@@ -0,0 +1,67 @@
+package lensvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// mergePatch applies the JSON Merge Patch (RFC 7386) written by a
+// lens to the document, and returns the patched document. An empty
+// patch leaves the document as it is.
+func mergePatch(doc, patch []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return doc, nil
+	}
+
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var target interface{}
+	if len(bytes.TrimSpace(doc)) > 0 {
+		target, err = decodeJSON(doc)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid input document: %w", err)
+		}
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+// mergeValue merges the patch value into the target value
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergeValue(t[k], v)
+	}
+	return t
+}
+
+// decodeJSON decodes a JSON value, keeping
+// numbers as they are written
+func decodeJSON(buf []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	return v, nil
+}
@@ -0,0 +1,57 @@
+package lensvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, out string
+	}{
+		// examples of RFC 7386
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{``, `{"a":1}`, `{"a":1}`},
+	}
+
+	for _, test := range tests {
+		out, err := mergePatch([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err, test.patch)
+		assert.JSONEq(t, test.out, string(out), test.patch)
+	}
+}
+
+func TestMergePatchNumbers(t *testing.T) {
+	out, err := mergePatch([]byte(`{"n":12345678901234567890}`), []byte(`{"m":1.50}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"m":1.50,"n":12345678901234567890}`, string(out))
+}
+
+func TestMergePatchEmpty(t *testing.T) {
+	doc := []byte(`{"a": 1}`)
+	out, err := mergePatch(doc, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, doc, out)
+
+	_, err = mergePatch(doc, []byte(`{"a":`))
+	assert.True(t, errors.Is(err, ErrInvalidPatch))
+
+	_, err = mergePatch([]byte(`{"a"`), []byte(`{}`))
+	assert.Error(t, err)
+}
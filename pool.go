@@ -0,0 +1,191 @@
+package lensvm
+
+import (
+	"context"
+	"sync"
+
+	stypes "github.com/lens-vm/lens-vm-go-sdk/types"
+)
+
+// instancePool is a bounded pool of workers, each of which is
+// a VM with its own instances of the compiled modules, and its
+// own buffers. Workers are created lazily, up to the pool size,
+// after which callers wait for a worker to be released.
+// Once the pool is closed, the workers in use are closed
+// when they are put back or discarded.
+type instancePool struct {
+	newWorker func() (*VM, error)
+
+	// slots holds a token for every created worker
+	// which isn't closed yet, so it's full once the
+	// pool holds as many workers as its size
+	slots chan struct{}
+	free  chan *VM
+
+	// mu orders put against close, so no worker
+	// is put back after the free ones are closed
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+func newInstancePool(size int, newWorker func() (*VM, error)) *instancePool {
+	return &instancePool{
+		newWorker: newWorker,
+		slots:     make(chan struct{}, size),
+		free:      make(chan *VM, size),
+		done:      make(chan struct{}),
+	}
+}
+
+// get returns a free worker, creating one if the pool isn't
+// full yet. Otherwise it waits until a worker is released,
+// or closed, or the context is done. It fails with
+// ErrInstanceNotStart once the pool is closed.
+func (p *instancePool) get(ctx context.Context) (*VM, error) {
+	select {
+	case <-p.done:
+		return nil, ErrInstanceNotStart
+	default:
+	}
+
+	select {
+	case w := <-p.free:
+		return w, nil
+	default:
+	}
+
+	select {
+	case w := <-p.free:
+		return w, nil
+	case p.slots <- struct{}{}:
+		if p.closed() {
+			p.release()
+			return nil, ErrInstanceNotStart
+		}
+		w, err := p.newWorker()
+		if err != nil {
+			p.release()
+			return nil, err
+		}
+		return w, nil
+	case <-p.done:
+		return nil, ErrInstanceNotStart
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// put returns the worker to the pool, or closes
+// it if the pool was closed while it was in use
+func (p *instancePool) put(w *VM) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed() {
+		w.closeInstances()
+		p.release()
+		return
+	}
+	p.free <- w
+}
+
+// discard closes the worker instead of returning it to the pool,
+// after an interrupted exec which left the guest in a bad state.
+// The abandoned calls of the worker, if any, must return first.
+// A new worker is created in its place when needed.
+func (p *instancePool) discard(w *VM) {
+	w.waitCalls()
+	w.closeInstances()
+	p.release()
+}
+
+func (p *instancePool) release() {
+	<-p.slots
+}
+
+func (p *instancePool) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes all the workers which aren't in use,
+// the ones in use are closed once they are released
+func (p *instancePool) close() {
+	p.mu.Lock()
+	if !p.closed() {
+		close(p.done)
+	}
+	p.mu.Unlock()
+
+	for {
+		select {
+		case w := <-p.free:
+			p.discard(w)
+		default:
+			return
+		}
+	}
+}
+
+// newWorker creates a worker VM which shares the compiled modules
+// and loaded lens file with the VM, but has its own module instances
+// and buffers, so it can exec concurrently with the VM and other
+// workers.
+func (vm *VM) newWorker() (*VM, error) {
+	w := &VM{
+		lensFile:      vm.lensFile,
+		moduleImports: make(map[string]*Module),
+		lensImports:   make(map[string]*Module),
+		engine:        vm.engine,
+		resolvers:     vm.resolvers,
+		resolverCtx:   vm.resolverCtx,
+		execCtx:       vm.execCtx,
+		lock:          vm.lock,
+		buffers:       make(map[stypes.BufferType][]byte),
+	}
+
+	for id, mod := range vm.moduleImports {
+		w.moduleImports[id] = mod.clone(w)
+	}
+	for id, mod := range vm.moduleImports {
+		clone := w.moduleImports[id]
+		for name, dep := range mod.dependancies {
+			clone.dependancies[name] = w.moduleImports[dep.id]
+		}
+	}
+	for name, mod := range vm.lensImports {
+		w.lensImports[name] = w.moduleImports[mod.id]
+	}
+
+	w.fuel = vm.fuel
+	w.execTimeout = vm.execTimeout
+	w.maxMemoryPages = vm.maxMemoryPages
+	w.budget = vm.budget
+	w.wasi = vm.wasi
+	w.hostFuncs = vm.hostFuncs
+	if err := w.Init(); err != nil {
+		w.closeInstances()
+		return nil, err
+	}
+	return w, nil
+}
+
+// clone creates an uninitialized copy of the module for the
+// given VM, which shares the compiled module. The dependancies
+// of the copy must be set by the caller.
+func (mod *Module) clone(vm *VM) *Module {
+	return &Module{
+		vm:           vm,
+		id:           mod.id,
+		definition:   mod.definition,
+		dependancies: make(map[string]*Module),
+		exportArgs:   mod.exportArgs,
+		argSchemas:   mod.argSchemas,
+		funcImports:  mod.funcImports,
+		wmod:         mod.wmod,
+		hostFuncs:    mod.hostFuncs,
+	}
+}
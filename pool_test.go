@@ -0,0 +1,249 @@
+package lensvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+	"github.com/lens-vm/lens-vm-go-host/engine/wazero"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEngines are the engines the pool is tested with,
+// wasmer is added when cgo is enabled
+var testEngines = map[string]func() engine.Engine{
+	"wazero": func() engine.Engine { return wazero.New() },
+}
+
+func TestInstancePoolBounded(t *testing.T) {
+	created := 0
+	pool := newInstancePool(2, func() (*VM, error) {
+		created++
+		return &VM{}, nil
+	})
+
+	ctx := context.Background()
+	w1, err := pool.get(ctx)
+	assert.NoError(t, err)
+	w2, err := pool.get(ctx)
+	assert.NoError(t, err)
+	assert.NotSame(t, w1, w2)
+	assert.Equal(t, 2, created)
+
+	// the pool is full, so get waits for a released worker
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.get(timeout)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	pool.put(w1)
+	w, err := pool.get(ctx)
+	assert.NoError(t, err)
+	assert.Same(t, w1, w)
+	assert.Equal(t, 2, created)
+}
+
+func TestInstancePoolDiscard(t *testing.T) {
+	created := 0
+	pool := newInstancePool(1, func() (*VM, error) {
+		created++
+		return &VM{}, nil
+	})
+
+	ctx := context.Background()
+	w, err := pool.get(ctx)
+	assert.NoError(t, err)
+	pool.discard(w)
+
+	// a new worker is created in place of the discarded one
+	w2, err := pool.get(ctx)
+	assert.NoError(t, err)
+	assert.NotSame(t, w, w2)
+	assert.Equal(t, 2, created)
+}
+
+func TestInstancePoolWorkerError(t *testing.T) {
+	fail := true
+	pool := newInstancePool(1, func() (*VM, error) {
+		if fail {
+			return nil, errors.New("failed")
+		}
+		return &VM{}, nil
+	})
+
+	_, err := pool.get(context.Background())
+	assert.Error(t, err)
+
+	// the failed worker doesn't count towards the pool size
+	fail = false
+	_, err = pool.get(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestInstancePoolClose(t *testing.T) {
+	pool := newInstancePool(2, func() (*VM, error) {
+		return &VM{}, nil
+	})
+
+	ctx := context.Background()
+	w1, err := pool.get(ctx)
+	require.NoError(t, err)
+	w2, err := pool.get(ctx)
+	require.NoError(t, err)
+	pool.put(w1)
+	pool.close()
+	assert.Len(t, pool.free, 0)
+	assert.Len(t, pool.slots, 1)
+
+	// the worker in use is closed when it's put back
+	pool.put(w2)
+	assert.Len(t, pool.free, 0)
+	assert.Len(t, pool.slots, 0)
+
+	_, err = pool.get(ctx)
+	assert.True(t, errors.Is(err, ErrInstanceNotStart), err)
+}
+
+func TestVMPoolWorker(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		PoolSize:  2,
+	})
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/importdeep/lens.json"))
+	require.NoError(t, err)
+	require.NoError(t, vm.Init())
+	defer vm.Close()
+	require.NotNil(t, vm.pool.Load())
+
+	w, err := vm.pool.Load().get(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, w.moduleImports, len(vm.moduleImports))
+
+	for id, mod := range w.moduleImports {
+		// only the workers instantiate the modules
+		assert.False(t, vm.moduleImports[id].initialized, id)
+		assert.True(t, mod.initialized, id)
+		assert.NotSame(t, vm.moduleImports[id], mod)
+		assert.Equal(t, vm.moduleImports[id].wmod, mod.wmod, id)
+
+		for name, dep := range mod.dependancies {
+			assert.Same(t, w.moduleImports[dep.id], dep, name)
+		}
+	}
+	vm.pool.Load().put(w)
+}
+
+func TestVMPoolConcurrentExec(t *testing.T) {
+	for name, newEngine := range testEngines {
+		t.Run(name, func(t *testing.T) {
+			vm := NewVM(&Options{
+				Resolvers: DefaultOptions.Resolvers,
+				Engine:    newEngine(),
+				PoolSize:  4,
+			})
+			require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json")))
+			require.NoError(t, vm.Init())
+			defer vm.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						body := fmt.Sprintf("hello %d-%d", i, j)
+						out, err := vm.Exec([]byte(fmt.Sprintf(`{"body": %q}`, body)))
+						if assert.NoError(t, err) {
+							assert.JSONEq(t, fmt.Sprintf(`{"body": %q, "description": %q}`, body, body), string(out))
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestVMPoolExecError(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		PoolSize:  1,
+	})
+	require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json")))
+	require.NoError(t, vm.Init())
+	defer vm.Close()
+
+	_, err := vm.Exec([]byte(`{}`))
+	require.NoError(t, err)
+	w := <-vm.pool.Load().free
+	vm.pool.Load().put(w)
+
+	// a failed lens leaves the worker usable, so
+	// it's returned to the pool instead of discarded
+	_, err = vm.Exec([]byte(`not json`))
+	assert.Error(t, err)
+	assert.Same(t, w, <-vm.pool.Load().free)
+}
+
+func TestVMPoolCloseInUse(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		PoolSize:  1,
+	})
+	require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json")))
+	require.NoError(t, vm.Init())
+
+	pool := vm.pool.Load()
+	w, err := pool.get(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, vm.Close())
+
+	// the worker is closed once the exec using it is done
+	_, err = w.exec(context.Background(), []byte(`{}`))
+	assert.NoError(t, err)
+	pool.put(w)
+	for id, mod := range w.moduleImports {
+		assert.False(t, mod.initialized, id)
+		assert.Nil(t, mod.winst, id)
+	}
+
+	_, err = vm.Exec([]byte(`{}`))
+	assert.True(t, errors.Is(err, ErrInstanceNotStart), err)
+}
+
+func TestVMPoolConcurrentClose(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		PoolSize:  2,
+	})
+	require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json")))
+	require.NoError(t, vm.Init())
+
+	// execs racing with Close either succeed, or fail
+	// once the pool is closed, see go test -race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, err := vm.Exec([]byte(`{"body": "hello"}`))
+				if errors.Is(err, ErrInstanceNotStart) {
+					return
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, vm.Close())
+	wg.Wait()
+}
@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lens-vm/lens-vm-go-host/internal/fsutil"
+	"github.com/lens-vm/lens-vm-go-host/resolvers"
+)
+
+var (
+	ErrCacheMiss       = errors.New("resolver cache miss")
+	ErrCacheCorrupt    = errors.New("resolver cache entry is corrupt")
+	ErrMissingCacheDir = errors.New("resolver cache directory is empty")
+)
+
+// DefaultTTL is how long cached entries are used
+// when the options don't set a TTL
+const DefaultTTL = 24 * time.Hour
+
+// Options configures a cached resolver
+type Options struct {
+	// Dir is the directory the cache is stored in.
+	// It can be shared by many resolvers and processes.
+	Dir string
+
+	// TTL is how long a cached entry is used before it
+	// is resolved again. If zero, DefaultTTL is used. If
+	// negative, entries NEVER expire, so targets which
+	// change over time, eg. version ranges and tags, keep
+	// resolving to what they first resolved to, until the
+	// entry is evicted.
+	TTL time.Duration
+
+	// Offline only serves entries from the cache, and
+	// never calls the wrapped resolver. Expired entries
+	// are still served.
+	Offline bool
+}
+
+// CachedResolver wraps a Resolver, and stores everything it
+// resolves on disk. The content is stored once per sha256
+// hash, and an index maps each URI to its content hash.
+type CachedResolver struct {
+	resolver resolvers.Resolver
+	opts     Options
+}
+
+// entry is an index entry for a single URI
+type entry struct {
+	URI      string    `json:"uri"`
+	Hash     string    `json:"hash"`
+	Resolved time.Time `json:"resolved"`
+
+	// Concrete is the concrete target the URI was resolved
+	// from, if it differs, see resolvers.ConcreteResolver
+	Concrete string `json:"concrete,omitempty"`
+}
+
+// New wraps the given resolver with a cache
+func New(r resolvers.Resolver, opts Options) (*CachedResolver, error) {
+	if opts.Dir == "" {
+		return nil, ErrMissingCacheDir
+	}
+	for _, dir := range []string{opts.indexDir(), opts.blobDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &CachedResolver{
+		resolver: r,
+		opts:     opts,
+	}, nil
+}
+
+func (o Options) indexDir() string {
+	return filepath.Join(o.Dir, "index")
+}
+
+func (o Options) blobDir() string {
+	return filepath.Join(o.Dir, "blobs")
+}
+
+func (c *CachedResolver) Scheme() string {
+	return c.resolver.Scheme()
+}
+
+func (c *CachedResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	buf, _, err := c.ResolveConcrete(ctx, target)
+	return buf, err
+}
+
+// ResolveConcrete resolves the target like Resolve, and returns the
+// concrete target of the wrapped resolver, which is cached along with
+// the content. The content is also cached for the concrete target.
+func (c *CachedResolver) ResolveConcrete(ctx context.Context, target string) ([]byte, string, error) {
+	return c.ResolveVersion(ctx, target, "")
+}
+
+// ResolveVersion resolves the target like ResolveConcrete, within the
+// semver range, see resolvers.ResolveVersion. The content is cached for
+// the target and the range, and for the concrete target.
+func (c *CachedResolver) ResolveVersion(ctx context.Context, target, rng string) ([]byte, string, error) {
+	uri := c.uri(target)
+	if rng != "" {
+		uri += " within " + rng
+	}
+	e, buf, err := c.load(uri)
+	switch {
+	case err == nil && (c.opts.Offline || !c.expired(e)):
+		if e.Concrete != "" {
+			return buf, e.Concrete, nil
+		}
+		return buf, target, nil
+	case c.opts.Offline:
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", fmt.Errorf("%w: %s", ErrCacheMiss, uri)
+		}
+		return nil, "", err
+	}
+
+	buf, concrete, err := resolvers.ResolveVersion(ctx, c.resolver, target, rng)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.uri(concrete) == uri {
+		err = c.store(uri, buf, "")
+	} else {
+		err = c.store(uri, buf, concrete)
+		if err == nil {
+			err = c.store(c.uri(concrete), buf, "")
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf, concrete, nil
+}
+
+// Evict removes the cached entry for the given URI.
+// The content is kept until the next Prune, as it
+// can be shared by other URIs.
+func (c *CachedResolver) Evict(uri string) error {
+	err := os.Remove(c.indexPath(c.uri(uri)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Prune removes all the expired entries, and all the
+// content that is no longer referenced by an entry.
+func (c *CachedResolver) Prune() error {
+	files, err := ioutil.ReadDir(c.opts.indexDir())
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, f := range files {
+		if fsutil.IsTemp(f.Name()) {
+			continue
+		}
+		path := filepath.Join(c.opts.indexDir(), f.Name())
+		e, err := readEntry(path)
+		if err != nil || c.expired(e) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		used[e.Hash] = true
+	}
+
+	blobs, err := ioutil.ReadDir(c.opts.blobDir())
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		if used[b.Name()] || fsutil.IsTemp(b.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.opts.blobDir(), b.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes everything from the cache
+func (c *CachedResolver) Purge() error {
+	for _, dir := range []string{c.opts.indexDir(), c.opts.blobDir()} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uri returns the full URI for the given target,
+// which may or may not include the scheme.
+func (c *CachedResolver) uri(target string) string {
+	prefix := c.Scheme() + "://"
+	if strings.HasPrefix(target, prefix) {
+		return target
+	}
+	return prefix + target
+}
+
+func (c *CachedResolver) expired(e entry) bool {
+	ttl := c.opts.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return ttl > 0 && time.Since(e.Resolved) > ttl
+}
+
+func (c *CachedResolver) indexPath(uri string) string {
+	return filepath.Join(c.opts.indexDir(), hash(uri)+".json")
+}
+
+// load returns the index entry and the content for the URI,
+// verifying that the content still matches its hash.
+func (c *CachedResolver) load(uri string) (entry, []byte, error) {
+	e, err := readEntry(c.indexPath(uri))
+	if err != nil {
+		return entry{}, nil, err
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(c.opts.blobDir(), e.Hash))
+	if err != nil {
+		return entry{}, nil, err
+	}
+	if hash(string(buf)) != e.Hash {
+		return entry{}, nil, fmt.Errorf("%w: %s", ErrCacheCorrupt, uri)
+	}
+	return e, buf, nil
+}
+
+// store writes the content and the index entry for the URI
+func (c *CachedResolver) store(uri string, buf []byte, concrete string) error {
+	e := entry{
+		URI:      uri,
+		Hash:     hash(string(buf)),
+		Resolved: time.Now().UTC(),
+		Concrete: concrete,
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(c.opts.blobDir(), e.Hash), buf); err != nil {
+		return err
+	}
+
+	index, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(c.indexPath(uri), index)
+}
+
+func readEntry(path string) (entry, error) {
+	var e entry
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(buf, &e)
+	return e, err
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingResolver struct {
+	calls   int
+	content map[string]string
+}
+
+func (r *countingResolver) Scheme() string {
+	return "test"
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	r.calls++
+	buf, ok := r.content[target]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return []byte(buf), nil
+}
+
+// concreteResolver resolves the latest tag to version 1
+type concreteResolver struct {
+	*countingResolver
+}
+
+func (r concreteResolver) ResolveConcrete(ctx context.Context, target string) ([]byte, string, error) {
+	concrete := strings.Replace(target, "@latest", "@1", 1)
+	buf, err := r.Resolve(ctx, concrete)
+	return buf, concrete, err
+}
+
+// versionResolver resolves the latest tag to the major
+// version of the range, or to version 1 without one
+type versionResolver struct {
+	concreteResolver
+}
+
+func (r versionResolver) ResolveVersion(ctx context.Context, target, rng string) ([]byte, string, error) {
+	concrete := strings.Replace(target, "@latest", "@"+strings.TrimPrefix(rng, "^"), 1)
+	buf, err := r.Resolve(ctx, concrete)
+	return buf, concrete, err
+}
+
+func newCached(t *testing.T, opts Options) (*CachedResolver, *countingResolver, func()) {
+	dir, err := ioutil.TempDir("", "lensvm-cache")
+	assert.NoError(t, err)
+
+	r := &countingResolver{content: map[string]string{
+		"a/module.json": `{"name": "rename"}`,
+		"b/module.json": `{"name": "rename"}`,
+	}}
+	opts.Dir = dir
+	c, err := New(r, opts)
+	assert.NoError(t, err)
+	return c, r, func() { os.RemoveAll(dir) }
+}
+
+func TestCachedResolve(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{})
+	defer cleanup()
+	assert.Equal(t, "test", c.Scheme())
+
+	for i := 0; i < 3; i++ {
+		buf, err := c.Resolve(context.Background(), "a/module.json")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name": "rename"}`, string(buf))
+	}
+	assert.Equal(t, 1, r.calls)
+
+	// the scheme prefix doesn't change the cache key
+	_, err := c.Resolve(context.Background(), "test://a/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.calls)
+
+	// identical content is only stored once
+	_, err = c.Resolve(context.Background(), "b/module.json")
+	assert.NoError(t, err)
+	blobs, err := ioutil.ReadDir(filepath.Join(c.opts.Dir, "blobs"))
+	assert.NoError(t, err)
+	assert.Len(t, blobs, 1)
+}
+
+func TestCachedResolveTTL(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{TTL: time.Millisecond})
+	defer cleanup()
+
+	_, err := c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.calls)
+
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, c.Prune())
+	blobs, err := ioutil.ReadDir(filepath.Join(c.opts.Dir, "blobs"))
+	assert.NoError(t, err)
+	assert.Len(t, blobs, 0)
+}
+
+func TestCachedExpired(t *testing.T) {
+	old := entry{Resolved: time.Now().Add(-2 * DefaultTTL)}
+	fresh := entry{Resolved: time.Now()}
+
+	c := &CachedResolver{}
+	assert.True(t, c.expired(old))
+	assert.False(t, c.expired(fresh))
+
+	// a negative TTL never expires
+	c.opts.TTL = -1
+	assert.False(t, c.expired(old))
+}
+
+func TestCachedResolveOffline(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{})
+	defer cleanup()
+
+	_, err := c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+
+	offline, err := New(r, Options{Dir: c.opts.Dir, Offline: true, TTL: time.Nanosecond})
+	assert.NoError(t, err)
+
+	buf, err := offline.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename"}`, string(buf))
+
+	_, err = offline.Resolve(context.Background(), "b/module.json")
+	assert.True(t, errors.Is(err, ErrCacheMiss))
+	assert.Equal(t, 1, r.calls)
+}
+
+func TestCachedResolveEvict(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{})
+	defer cleanup()
+
+	_, err := c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	assert.NoError(t, c.Evict("test://a/module.json"))
+	_, err = c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.calls)
+
+	assert.NoError(t, c.Purge())
+	_, err = c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.calls)
+}
+
+func TestCachedResolveCorrupt(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{})
+	defer cleanup()
+
+	_, err := c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+
+	e, err := readEntry(c.indexPath("test://a/module.json"))
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(c.opts.Dir, "blobs", e.Hash), []byte("evil"), 0644)
+	assert.NoError(t, err)
+
+	buf, err := c.Resolve(context.Background(), "a/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename"}`, string(buf))
+	assert.Equal(t, 2, r.calls)
+}
+
+func TestCachedResolveConcrete(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{})
+	defer cleanup()
+	r.content["a@1/module.json"] = `{"name": "pinned"}`
+	c.resolver = concreteResolver{r}
+
+	for i := 0; i < 2; i++ {
+		buf, concrete, err := c.ResolveConcrete(context.Background(), "a@latest/module.json")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name": "pinned"}`, string(buf))
+		assert.Equal(t, "a@1/module.json", concrete)
+	}
+	assert.Equal(t, 1, r.calls)
+
+	// the content is also cached for the concrete target
+	buf, concrete, err := c.ResolveConcrete(context.Background(), "a@1/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "pinned"}`, string(buf))
+	assert.Equal(t, "a@1/module.json", concrete)
+	assert.Equal(t, 1, r.calls)
+}
+
+func TestCachedResolveVersion(t *testing.T) {
+	c, r, cleanup := newCached(t, Options{})
+	defer cleanup()
+	r.content["a@1/module.json"] = `{"name": "pinned"}`
+	r.content["a@2/module.json"] = `{"name": "ranged"}`
+	c.resolver = versionResolver{concreteResolver{r}}
+
+	// the range is passed on, and is part of the cache key
+	for i := 0; i < 2; i++ {
+		buf, concrete, err := c.ResolveVersion(context.Background(), "a@latest/module.json", "^2")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name": "ranged"}`, string(buf))
+		assert.Equal(t, "a@2/module.json", concrete)
+	}
+	assert.Equal(t, 1, r.calls)
+
+	buf, concrete, err := c.ResolveConcrete(context.Background(), "a@latest/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "pinned"}`, string(buf))
+	assert.Equal(t, "a@1/module.json", concrete)
+	assert.Equal(t, 2, r.calls)
+}
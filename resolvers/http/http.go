@@ -0,0 +1,89 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrSchemeMismatch is returned for a target whose
+// scheme isn't the scheme of the resolver
+var ErrSchemeMismatch = errors.New("URI scheme doesn't match the resolver")
+
+// HTTPResolver resolves http:// URIs, or https://
+// URIs if Secure is set, with a GET request.
+// A target without a scheme gets the scheme of
+// the resolver, any other scheme is rejected.
+type HTTPResolver struct {
+	// Secure makes the resolver handle the https scheme
+	Secure bool
+
+	// Client is the client used to make requests.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Timeout limits the time taken by each request,
+	// in addition to any deadline on the resolve context.
+	// Zero means no timeout.
+	Timeout time.Duration
+
+	// Header is added to every request
+	Header http.Header
+}
+
+// IsSecure reports whether the URL has the https scheme,
+// so it must be resolved by a Secure resolver.
+func IsSecure(url string) bool {
+	return strings.HasPrefix(url, "https://")
+}
+
+func (h HTTPResolver) Scheme() string {
+	if h.Secure {
+		return "https"
+	}
+	return "http"
+}
+
+func (h HTTPResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	url := target
+	if i := strings.Index(url, "://"); i < 0 {
+		url = h.Scheme() + "://" + url
+	} else if url[:i] != h.Scheme() {
+		return nil, fmt.Errorf("%w: %s is not an %s URI", ErrSchemeMismatch, target, h.Scheme())
+	}
+
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range h.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to resolve %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPResolve(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/module.json", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		w.Write([]byte(`{"name": "rename"}`))
+	}))
+	defer srv.Close()
+
+	res := HTTPResolver{
+		Header: http.Header{"X-Token": []string{"secret"}},
+	}
+	assert.Equal(t, "http", res.Scheme())
+
+	buf, err := res.Resolve(context.Background(), strings.TrimPrefix(srv.URL, "http://")+"/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename"}`, string(buf))
+}
+
+func TestHTTPSResolve(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("wasm"))
+	}))
+	defer srv.Close()
+
+	res := HTTPResolver{
+		Secure: true,
+		Client: srv.Client(),
+	}
+	assert.Equal(t, "https", res.Scheme())
+
+	buf, err := res.Resolve(context.Background(), srv.URL+"/main.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm", string(buf))
+}
+
+func TestHTTPResolveSchemeMismatch(t *testing.T) {
+	_, err := HTTPResolver{}.Resolve(context.Background(), "https://example.com/module.json")
+	assert.True(t, errors.Is(err, ErrSchemeMismatch))
+
+	_, err = HTTPResolver{Secure: true}.Resolve(context.Background(), "http://example.com/module.json")
+	assert.True(t, errors.Is(err, ErrSchemeMismatch))
+
+	assert.True(t, IsSecure("https://example.com"))
+	assert.False(t, IsSecure("http://example.com"))
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTPResolveHeader(t *testing.T) {
+	var header http.Header
+	client := &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		header = req.Header
+		return nil, errors.New("unreachable")
+	})}
+
+	// the header keys are canonicalized, and the values of
+	// keys which are the same once canonicalized are kept
+	res := HTTPResolver{
+		Client: client,
+		Header: http.Header{"x-token": []string{"a"}, "X-TOKEN": []string{"b"}},
+	}
+	_, err := res.Resolve(context.Background(), "example.com/module.json")
+	assert.Error(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, header["X-Token"])
+	assert.NotContains(t, header, "x-token")
+}
+
+func TestHTTPResolveNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := HTTPResolver{}.Resolve(context.Background(), srv.URL+"/missing.json")
+	assert.Error(t, err)
+}
+
+func TestHTTPResolveTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	_, err := HTTPResolver{Timeout: 50 * time.Millisecond}.Resolve(context.Background(), srv.URL)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = HTTPResolver{}.Resolve(ctx, srv.URL)
+	assert.Error(t, err)
+}
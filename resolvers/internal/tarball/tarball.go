@@ -0,0 +1,46 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// ReadFile returns the contents of the named file in the given
+// gzipped tarball. Leading "./" and the given prefix are stripped
+// from the entry names before matching.
+func ReadFile(tgz []byte, prefix, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		entry := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		entry = strings.TrimPrefix(entry, prefix)
+		if entry == name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+
+	return nil, fmt.Errorf("File %s not found in archive", name)
+}
@@ -0,0 +1,47 @@
+package version
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// Highest returns the highest of the given versions
+// that matches the semver range. Versions that aren't
+// valid semver are ignored.
+func Highest(rng string, versions []string) (string, error) {
+	constraint, err := semver.NewConstraint(rng)
+	if err != nil {
+		return "", err
+	}
+
+	var best *semver.Version
+	var selected string
+	for _, s := range versions {
+		v, err := semver.NewVersion(s)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best = v
+			selected = s
+		}
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("No version matches '%s'", rng)
+	}
+	return selected, nil
+}
+
+// Within reports whether the version matches the semver
+// range. A version that isn't valid semver never matches.
+func Within(rng, ver string) (bool, error) {
+	constraint, err := semver.NewConstraint(rng)
+	if err != nil {
+		return false, err
+	}
+
+	v, err := semver.NewVersion(ver)
+	return err == nil && constraint.Check(v), nil
+}
@@ -0,0 +1,190 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Multicodec codes used by the resolver
+const (
+	codecRaw    = 0x55
+	codecDagPB  = 0x70
+	hashSHA2256 = 0x12
+)
+
+var (
+	ErrInvalidCID          = errors.New("invalid CID")
+	ErrUnsupportedCodec    = errors.New("unsupported CID codec")
+	ErrUnsupportedHash     = errors.New("unsupported CID hash function")
+	ErrContentHashMismatch = errors.New("content does not match CID")
+)
+
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// cid is a parsed content identifier
+type cid struct {
+	version  uint64
+	codec    uint64
+	hashCode uint64
+	digest   []byte
+}
+
+// parseCID parses the string form of a CIDv0 or CIDv1. CIDv1
+// strings can use the base32, base58btc or base16 multibase.
+func parseCID(s string) (cid, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		buf, err := decodeBase58(s)
+		if err != nil {
+			return cid{}, err
+		}
+		return decodeCID(buf)
+	}
+	if len(s) < 2 {
+		return cid{}, ErrInvalidCID
+	}
+
+	var buf []byte
+	var err error
+	switch s[0] {
+	case 'b':
+		buf, err = base32Encoding.DecodeString(strings.ToUpper(s[1:]))
+	case 'z':
+		buf, err = decodeBase58(s[1:])
+	case 'f':
+		buf, err = hex.DecodeString(s[1:])
+	default:
+		return cid{}, fmt.Errorf("%w: unsupported multibase '%c'", ErrInvalidCID, s[0])
+	}
+	if err != nil {
+		return cid{}, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	return decodeCID(buf)
+}
+
+// decodeCID decodes the binary form of a CID
+func decodeCID(buf []byte) (cid, error) {
+	// CIDv0 is a bare sha2-256 multihash
+	if len(buf) == 34 && buf[0] == hashSHA2256 && buf[1] == 32 {
+		return cid{
+			version:  0,
+			codec:    codecDagPB,
+			hashCode: hashSHA2256,
+			digest:   buf[2:],
+		}, nil
+	}
+
+	r := bytes.NewReader(buf)
+	version, err := binary.ReadUvarint(r)
+	if err != nil || version != 1 {
+		return cid{}, ErrInvalidCID
+	}
+	codec, err := binary.ReadUvarint(r)
+	if err != nil {
+		return cid{}, ErrInvalidCID
+	}
+	hashCode, err := binary.ReadUvarint(r)
+	if err != nil {
+		return cid{}, ErrInvalidCID
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size != uint64(r.Len()) {
+		return cid{}, ErrInvalidCID
+	}
+
+	digest := make([]byte, size)
+	r.Read(digest)
+	return cid{
+		version:  version,
+		codec:    codec,
+		hashCode: hashCode,
+		digest:   digest,
+	}, nil
+}
+
+// bytes returns the binary form of the CID
+func (c cid) bytes() []byte {
+	mh := append([]byte{byte(c.hashCode), byte(len(c.digest))}, c.digest...)
+	if c.version == 0 {
+		return mh
+	}
+
+	buf := make([]byte, 0, len(mh)+2*binary.MaxVarintLen64)
+	buf = appendUvarint(buf, c.version)
+	buf = appendUvarint(buf, c.codec)
+	return append(buf, mh...)
+}
+
+// String returns the canonical string form of the CID,
+// base58btc for CIDv0 and base32 for CIDv1.
+func (c cid) String() string {
+	if c.version == 0 {
+		return encodeBase58(c.bytes())
+	}
+	return "b" + strings.ToLower(base32Encoding.EncodeToString(c.bytes()))
+}
+
+// verify checks that the given block content hashes
+// to the CID digest.
+func (c cid) verify(block []byte) error {
+	if c.hashCode != hashSHA2256 {
+		return fmt.Errorf("%w: 0x%x", ErrUnsupportedHash, c.hashCode)
+	}
+
+	sum := sha256.Sum256(block)
+	if !bytes.Equal(sum[:], c.digest) {
+		return fmt.Errorf("%w: %s", ErrContentHashMismatch, c)
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, v)
+	return append(buf, tmp[:n]...)
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	zeros := 0
+	for i := 0; i < len(s) && s[i] == base58Alphabet[0]; i++ {
+		zeros++
+	}
+	for _, r := range s {
+		idx := strings.IndexRune(base58Alphabet, r)
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: invalid base58 character '%c'", ErrInvalidCID, r)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(idx)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func encodeBase58(buf []byte) string {
+	n := new(big.Int).SetBytes(buf)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < len(buf) && buf[i] == 0; i++ {
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
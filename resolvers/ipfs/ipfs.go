@@ -0,0 +1,169 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	httpresolver "github.com/lens-vm/lens-vm-go-host/resolvers/http"
+)
+
+// DefaultGatewayURL is the gateway used when
+// no Blockstore is given to the resolver.
+const DefaultGatewayURL = "https://ipfs.io"
+
+// maxDepth limits the depth of the file DAGs
+// that the resolver will follow.
+const maxDepth = 32
+
+// Blockstore is a source of raw IPFS blocks
+type Blockstore interface {
+	// Get returns the raw bytes of the block
+	// with the given CID.
+	Get(ctx context.Context, cid string) ([]byte, error)
+}
+
+// Gateway is a Blockstore that fetches blocks from an
+// HTTP gateway supporting raw block responses.
+type Gateway struct {
+	// URL is the gateway base URL, eg. https://ipfs.io
+	URL string
+
+	// Client is the client used to make requests.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (g Gateway) Get(ctx context.Context, cid string) ([]byte, error) {
+	u := strings.TrimSuffix(g.URL, "/") + "/ipfs/" + cid + "?format=raw"
+	res := httpresolver.HTTPResolver{
+		Secure: httpresolver.IsSecure(u),
+		Client: g.Client,
+		Header: http.Header{"Accept": []string{"application/vnd.ipld.raw"}},
+	}
+	return res.Resolve(ctx, u)
+}
+
+// IPFSResolver resolves ipfs://<cid>[/<path>] URIs. Every block
+// is verified against its CID before it is used, so the resolved
+// content always matches the requested CID.
+type IPFSResolver struct {
+	// Blocks is the store blocks are fetched from. If nil,
+	// blocks are fetched from the DefaultGatewayURL gateway.
+	Blocks Blockstore
+}
+
+func (r IPFSResolver) Scheme() string {
+	return "ipfs"
+}
+
+func (r IPFSResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	target = strings.TrimPrefix(target, r.Scheme()+"://")
+	parts := strings.Split(strings.Trim(target, "/"), "/")
+
+	c, err := parseCID(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range parts[1:] {
+		c, err = r.resolveLink(ctx, c, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.readFile(ctx, c, 0)
+}
+
+func (r IPFSResolver) blockstore() Blockstore {
+	if r.Blocks == nil {
+		return Gateway{URL: DefaultGatewayURL}
+	}
+	return r.Blocks
+}
+
+// getBlock fetches the block with the given CID,
+// and verifies it matches the CID.
+func (r IPFSResolver) getBlock(ctx context.Context, c cid) ([]byte, error) {
+	block, err := r.blockstore().Get(ctx, c.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verify(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// getNode fetches the block with the given CID, and
+// decodes it as a dag-pb node with UnixFS data.
+func (r IPFSResolver) getNode(ctx context.Context, c cid) (pbNode, unixfsData, error) {
+	if c.codec != codecDagPB {
+		return pbNode{}, unixfsData{}, fmt.Errorf("%w: 0x%x", ErrUnsupportedCodec, c.codec)
+	}
+
+	block, err := r.getBlock(ctx, c)
+	if err != nil {
+		return pbNode{}, unixfsData{}, err
+	}
+	node, err := decodePBNode(block)
+	if err != nil {
+		return pbNode{}, unixfsData{}, err
+	}
+	fs, err := decodeUnixFS(node.data)
+	return node, fs, err
+}
+
+// resolveLink returns the CID of the named entry
+// in the directory with the given CID.
+func (r IPFSResolver) resolveLink(ctx context.Context, c cid, name string) (cid, error) {
+	node, fs, err := r.getNode(ctx, c)
+	if err != nil {
+		return cid{}, err
+	}
+	if fs.typ != unixfsDirectory {
+		return cid{}, fmt.Errorf("Cannot resolve '%s', %s is not a directory", name, c)
+	}
+
+	for _, link := range node.links {
+		if link.name == name {
+			return decodeCID(link.hash)
+		}
+	}
+	return cid{}, fmt.Errorf("Directory %s has no entry '%s'", c, name)
+}
+
+// readFile returns the contents of the file with the given CID,
+// by concatenating all the data in its DAG.
+func (r IPFSResolver) readFile(ctx context.Context, c cid, depth int) ([]byte, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("File DAG of %s is too deep", c)
+	}
+
+	if c.codec == codecRaw {
+		return r.getBlock(ctx, c)
+	}
+
+	node, fs, err := r.getNode(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if fs.typ != unixfsFile && fs.typ != unixfsRaw {
+		return nil, fmt.Errorf("%s is not a file", c)
+	}
+
+	out := append([]byte{}, fs.data...)
+	for _, link := range node.links {
+		child, err := decodeCID(link.hash)
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.readFile(ctx, child, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data...)
+	}
+	return out, nil
+}
@@ -0,0 +1,141 @@
+package ipfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapBlockstore map[string][]byte
+
+func (m mapBlockstore) Get(ctx context.Context, c string) ([]byte, error) {
+	block, ok := m[c]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return block, nil
+}
+
+func (m mapBlockstore) put(codec uint64, block []byte) cid {
+	sum := sha256.Sum256(block)
+	c := cid{version: 1, codec: codec, hashCode: hashSHA2256, digest: sum[:]}
+	m[c.String()] = block
+	return c
+}
+
+func (m mapBlockstore) putV0(block []byte) cid {
+	sum := sha256.Sum256(block)
+	c := cid{version: 0, codec: codecDagPB, hashCode: hashSHA2256, digest: sum[:]}
+	m[c.String()] = block
+	return c
+}
+
+func protoBytes(field int, b []byte) []byte {
+	buf := appendUvarint(nil, uint64(field<<3|2))
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func protoVarint(field int, v uint64) []byte {
+	buf := appendUvarint(nil, uint64(field<<3))
+	return appendUvarint(buf, v)
+}
+
+type pbTestLink struct {
+	name string
+	c    cid
+}
+
+func pbNodeBytes(typ uint64, data []byte, links ...pbTestLink) []byte {
+	var buf []byte
+	for _, l := range links {
+		link := append(protoBytes(1, l.c.bytes()), protoBytes(2, []byte(l.name))...)
+		buf = append(buf, protoBytes(2, link)...)
+	}
+	fs := protoVarint(1, typ)
+	if data != nil {
+		fs = append(fs, protoBytes(2, data)...)
+	}
+	return append(buf, protoBytes(1, fs)...)
+}
+
+func TestParseCID(t *testing.T) {
+	// the well known empty UnixFS directory
+	c, err := parseCID("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), c.version)
+	assert.Equal(t, uint64(codecDagPB), c.codec)
+	assert.Equal(t, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", c.String())
+	assert.NoError(t, c.verify([]byte{0x0a, 0x02, 0x08, 0x01}))
+
+	raw := mapBlockstore{}.put(codecRaw, []byte("hello"))
+	c, err = parseCID(raw.String())
+	assert.NoError(t, err)
+	assert.Equal(t, raw, c)
+
+	_, err = parseCID("xnotacid")
+	assert.True(t, errors.Is(err, ErrInvalidCID))
+}
+
+func TestIPFSResolveRaw(t *testing.T) {
+	blocks := mapBlockstore{}
+	c := blocks.put(codecRaw, []byte("wasm bytes"))
+
+	res := IPFSResolver{Blocks: blocks}
+	assert.Equal(t, "ipfs", res.Scheme())
+
+	buf, err := res.Resolve(context.Background(), c.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm bytes", string(buf))
+}
+
+func TestIPFSResolveChunkedFileInDirectory(t *testing.T) {
+	blocks := mapBlockstore{}
+	chunk1 := blocks.put(codecRaw, []byte(`{"name": `))
+	chunk2 := blocks.putV0(pbNodeBytes(unixfsFile, []byte(`"rename"}`)))
+	file := blocks.putV0(pbNodeBytes(unixfsFile, nil, pbTestLink{"", chunk1}, pbTestLink{"", chunk2}))
+	dir := blocks.putV0(pbNodeBytes(unixfsDirectory, nil, pbTestLink{"module.json", file}))
+
+	res := IPFSResolver{Blocks: blocks}
+	_, err := res.Resolve(context.Background(), "ipfs://"+dir.String()+"/missing.json")
+	assert.Error(t, err)
+
+	buf, err := res.Resolve(context.Background(), "ipfs://"+dir.String()+"/module.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename"}`, string(buf))
+}
+
+func TestIPFSResolveTampered(t *testing.T) {
+	blocks := mapBlockstore{}
+	c := blocks.put(codecRaw, []byte("wasm bytes"))
+	blocks[c.String()] = []byte("evil bytes")
+
+	_, err := IPFSResolver{Blocks: blocks}.Resolve(context.Background(), c.String())
+	assert.True(t, errors.Is(err, ErrContentHashMismatch))
+}
+
+func TestIPFSResolveGateway(t *testing.T) {
+	blocks := mapBlockstore{}
+	c := blocks.put(codecRaw, []byte("wasm bytes"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "raw", r.URL.Query().Get("format"))
+		block, err := blocks.Get(r.Context(), r.URL.Path[len("/ipfs/"):])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(block)
+	}))
+	defer srv.Close()
+
+	res := IPFSResolver{Blocks: Gateway{URL: srv.URL}}
+	buf, err := res.Resolve(context.Background(), c.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm bytes", string(buf))
+}
@@ -0,0 +1,132 @@
+package ipfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMalformedNode = errors.New("malformed dag-pb node")
+
+// UnixFS data types
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+)
+
+// pbNode is a decoded dag-pb node
+type pbNode struct {
+	links []pbLink
+	data  []byte
+}
+
+type pbLink struct {
+	hash []byte
+	name string
+}
+
+// unixfsData is the decoded UnixFS Data field of a dag-pb node
+type unixfsData struct {
+	typ  uint64
+	data []byte
+}
+
+func decodePBNode(buf []byte) (pbNode, error) {
+	var node pbNode
+	err := readProtoFields(buf, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			node.data = b
+		case 2:
+			link, err := decodePBLink(b)
+			if err != nil {
+				return err
+			}
+			node.links = append(node.links, link)
+		}
+		return nil
+	})
+	return node, err
+}
+
+func decodePBLink(buf []byte) (pbLink, error) {
+	var link pbLink
+	err := readProtoFields(buf, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			link.hash = b
+		case 2:
+			link.name = string(b)
+		}
+		return nil
+	})
+	if err == nil && link.hash == nil {
+		err = ErrMalformedNode
+	}
+	return link, err
+}
+
+func decodeUnixFS(buf []byte) (unixfsData, error) {
+	var fs unixfsData
+	err := readProtoFields(buf, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			fs.typ = v
+		case 2:
+			fs.data = b
+		}
+		return nil
+	})
+	return fs, err
+}
+
+// readProtoFields walks over the fields of a protobuf message, calling fn
+// with the value of each varint field, or the bytes of each length
+// delimited field. Fixed width fields are skipped.
+func readProtoFields(buf []byte, fn func(field int, v uint64, b []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return ErrMalformedNode
+		}
+		buf = buf[n:]
+
+		field := int(key >> 3)
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			v, n = binary.Uvarint(buf)
+			if n <= 0 {
+				return ErrMalformedNode
+			}
+			buf = buf[n:]
+		case 1:
+			if len(buf) < 8 {
+				return ErrMalformedNode
+			}
+			buf = buf[8:]
+			continue
+		case 2:
+			size, n := binary.Uvarint(buf)
+			if n <= 0 || size > uint64(len(buf)-n) {
+				return ErrMalformedNode
+			}
+			b = buf[n : n+int(size)]
+			buf = buf[n+int(size):]
+		case 5:
+			if len(buf) < 4 {
+				return ErrMalformedNode
+			}
+			buf = buf[4:]
+			continue
+		default:
+			return ErrMalformedNode
+		}
+
+		if err := fn(field, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package npm
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/lens-vm/lens-vm-go-host/internal/sri"
+	httpresolver "github.com/lens-vm/lens-vm-go-host/resolvers/http"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/internal/tarball"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/internal/version"
+)
+
+// DefaultRegistryURL is the registry used when
+// none is given to the resolver.
+const DefaultRegistryURL = "https://registry.npmjs.org"
+
+// DefaultFile is the file resolved from a
+// package when the URI has no path.
+const DefaultFile = "module.json"
+
+// tarballPrefix is the directory npm packs
+// all the package files into.
+const tarballPrefix = "package/"
+
+// NPMResolver resolves npm://<package>@<version>[/<path>] URIs,
+// including scoped @<scope>/<name> packages. The version can be
+// an exact version, a semver range or a dist-tag such as latest.
+// Without a path the module.json file of the package is resolved.
+//
+// By default the package tarball is downloaded from the registry,
+// verified against its published integrity, and unpacked in memory.
+// If CDN is set, files are fetched directly from an unpkg style CDN.
+//
+// The resolver selects the version of every package and range once,
+// and downloads and verifies the tarball of every package version
+// once, so all the files of a package are resolved from the same
+// version. It must be used through a pointer, and not be copied
+// after its first use.
+type NPMResolver struct {
+	// Registry is the base URL of the npm registry.
+	// If empty, DefaultRegistryURL is used.
+	Registry string
+
+	// CDN is the base URL of an unpkg style CDN, eg.
+	// https://unpkg.com, serving <package>@<version>/<path>
+	CDN string
+
+	// Client is the client used to make requests.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// mu guards the selected versions, keyed by package and
+	// range, and the verified tarballs, keyed by package and
+	// version
+	mu       sync.Mutex
+	versions map[string]selectedVersion
+	tarballs map[string][]byte
+}
+
+type packument struct {
+	DistTags map[string]string         `json:"dist-tags"`
+	Versions map[string]packageVersion `json:"versions"`
+}
+
+type packageVersion struct {
+	Dist struct {
+		Tarball   string `json:"tarball"`
+		Shasum    string `json:"shasum"`
+		Integrity string `json:"integrity"`
+	} `json:"dist"`
+}
+
+type selectedVersion struct {
+	version string
+	pv      packageVersion
+}
+
+func (n *NPMResolver) Scheme() string {
+	return "npm"
+}
+
+func (n *NPMResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	buf, _, err := n.ResolveConcrete(ctx, target)
+	return buf, err
+}
+
+// ResolveConcrete resolves the target like Resolve, and returns the
+// target of the exact version of the package it resolved. The CDN
+// doesn't report the version it serves, so with a CDN the target is
+// returned as is.
+func (n *NPMResolver) ResolveConcrete(ctx context.Context, target string) ([]byte, string, error) {
+	return n.ResolveVersion(ctx, target, "")
+}
+
+// ResolveVersion resolves the target like ResolveConcrete, with a
+// version within the given semver range, see resolvers.VersionResolver.
+// A target without a version selects the highest version in the range,
+// instead of the latest dist-tag. The CDN can't narrow the version of
+// a target which has one, so it's resolved as is.
+func (n *NPMResolver) ResolveVersion(ctx context.Context, target, within string) ([]byte, string, error) {
+	target = strings.TrimPrefix(target, n.Scheme()+"://")
+	name, rng, file, err := parseTarget(target)
+	if err != nil {
+		return nil, "", err
+	}
+	if rng == "latest" && within != "" {
+		rng, within = within, ""
+	}
+
+	if n.CDN != "" {
+		u := fmt.Sprintf("%s/%s@%s/%s", strings.TrimSuffix(n.CDN, "/"), name, rng, file)
+		res := httpresolver.HTTPResolver{Client: n.Client, Secure: httpresolver.IsSecure(u)}
+		buf, err := res.Resolve(ctx, u)
+		return buf, target, err
+	}
+
+	selected, err := n.selectVersion(ctx, name, rng, within)
+	if err != nil {
+		return nil, "", err
+	}
+	tgz, err := n.tarball(ctx, name, selected)
+	if err != nil {
+		return nil, "", err
+	}
+	buf, err := tarball.ReadFile(tgz, tarballPrefix, file)
+	if err != nil {
+		return nil, "", err
+	}
+	return buf, fmt.Sprintf("%s@%s/%s", name, selected.version, file), nil
+}
+
+// tarball returns the verified tarball of the package
+// version, which is only downloaded once
+func (n *NPMResolver) tarball(ctx context.Context, name string, selected selectedVersion) ([]byte, error) {
+	key := name + "@" + selected.version
+	n.mu.Lock()
+	tgz, ok := n.tarballs[key]
+	n.mu.Unlock()
+	if ok {
+		return tgz, nil
+	}
+
+	u := selected.pv.Dist.Tarball
+	res := httpresolver.HTTPResolver{Client: n.Client, Secure: httpresolver.IsSecure(u)}
+	tgz, err := res.Resolve(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyTarball(tgz, selected.pv); err != nil {
+		return nil, fmt.Errorf("Failed to verify npm package %s: %w", key, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.tarballs == nil {
+		n.tarballs = make(map[string][]byte)
+	}
+	n.tarballs[key] = tgz
+	return tgz, nil
+}
+
+// parseTarget splits a [@<scope>/]<name>[@<version>][/<path>]
+// target into its parts. A missing version resolves the
+// latest dist-tag.
+func parseTarget(target string) (name, rng, file string, err error) {
+	rng = "latest"
+	file = DefaultFile
+
+	// the package name is one path segment, or two if scoped
+	segments := 1
+	if strings.HasPrefix(target, "@") {
+		segments = 2
+	}
+	parts := strings.SplitN(target, "/", segments+1)
+	if len(parts) < segments {
+		return "", "", "", fmt.Errorf("Malformed npm package '%s'", target)
+	}
+	if len(parts) > segments {
+		file = parts[segments]
+	}
+	name = strings.Join(parts[:segments], "/")
+
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		name, rng = name[:i], name[i+1:]
+	}
+	if name == "" || rng == "" || file == "" {
+		return "", "", "", fmt.Errorf("Malformed npm package '%s'", target)
+	}
+	return name, rng, file, nil
+}
+
+// selectVersion returns the version of the named package matching
+// the given dist-tag or semver range, and the within range if any,
+// which is only selected once.
+func (n *NPMResolver) selectVersion(ctx context.Context, name, rng, within string) (selectedVersion, error) {
+	key := name + "@" + rng
+	if within != "" {
+		key += " within " + within
+	}
+	n.mu.Lock()
+	selected, ok := n.versions[key]
+	n.mu.Unlock()
+	if ok {
+		return selected, nil
+	}
+
+	registry := n.Registry
+	if registry == "" {
+		registry = DefaultRegistryURL
+	}
+
+	u := strings.TrimSuffix(registry, "/") + "/" + url.PathEscape(name)
+	res := httpresolver.HTTPResolver{
+		Secure: httpresolver.IsSecure(u),
+		Client: n.Client,
+		Header: http.Header{"Accept": []string{"application/json"}},
+	}
+	buf, err := res.Resolve(ctx, u)
+	if err != nil {
+		return selectedVersion{}, err
+	}
+
+	var pkg packument
+	if err := json.Unmarshal(buf, &pkg); err != nil {
+		return selectedVersion{}, err
+	}
+
+	ver, ok := pkg.DistTags[rng]
+	if !ok {
+		versions := make([]string, 0, len(pkg.Versions))
+		for v := range pkg.Versions {
+			versions = append(versions, v)
+		}
+		ver, err = version.Highest(rng, versions)
+		if err != nil {
+			return selectedVersion{}, fmt.Errorf("Failed to select version of npm package %s: %w", name, err)
+		}
+	}
+	if within != "" {
+		ok, err := version.Within(within, ver)
+		if err != nil {
+			return selectedVersion{}, fmt.Errorf("Failed to select version of npm package %s: %w", name, err)
+		}
+		if !ok {
+			return selectedVersion{}, fmt.Errorf("npm package %s@%s is not within '%s'", name, ver, within)
+		}
+	}
+
+	pv, ok := pkg.Versions[ver]
+	if !ok || pv.Dist.Tarball == "" {
+		return selectedVersion{}, fmt.Errorf("npm package %s@%s has no tarball", name, ver)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.versions == nil {
+		n.versions = make(map[string]selectedVersion)
+	}
+	selected = selectedVersion{version: ver, pv: pv}
+	n.versions[key] = selected
+	return selected, nil
+}
+
+// verifyTarball checks the tarball against the integrity published
+// in the registry, or its shasum if the integrity has no supported
+// hash, eg. a legacy sha1 one.
+func verifyTarball(tgz []byte, pv packageVersion) error {
+	if pv.Dist.Integrity != "" {
+		ok, err := sri.Verify(pv.Dist.Integrity, tgz)
+		if err == nil && !ok {
+			return fmt.Errorf("Integrity mismatch, expected %s", pv.Dist.Integrity)
+		}
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, sri.ErrNoSupportedHash) || pv.Dist.Shasum == "" {
+			return fmt.Errorf("Malformed integrity '%s': %w", pv.Dist.Integrity, err)
+		}
+	}
+
+	if pv.Dist.Shasum != "" {
+		sum := sha1.Sum(tgz)
+		if hex.EncodeToString(sum[:]) != pv.Dist.Shasum {
+			return fmt.Errorf("Shasum mismatch, expected %s", pv.Dist.Shasum)
+		}
+	}
+	return nil
+}
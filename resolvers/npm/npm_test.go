@@ -0,0 +1,227 @@
+package npm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		assert.NoError(t, err)
+		_, err = tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// registry counts the package lookups and downloads of the registry
+type registry struct {
+	*httptest.Server
+	lookups   int
+	downloads int
+}
+
+func newRegistry(t *testing.T, tamper bool) *registry {
+	mux := http.NewServeMux()
+	srv := &registry{Server: httptest.NewServer(mux)}
+
+	versions := map[string]interface{}{}
+	for _, v := range []string{"1.3.1", "1.4.1", "2.0.0-rc.1"} {
+		tgz := makeTarball(t, map[string]string{
+			"package/package.json": `{"name": "@lens-vm/rename"}`,
+			"package/module.json":  `{"name": "rename", "version": "` + v + `"}`,
+			"package/rename.wasm":  "wasm " + v,
+		})
+		sum := sha512.Sum512(tgz)
+		if tamper {
+			tgz = makeTarball(t, map[string]string{"package/module.json": `{"name": "evil"}`})
+		}
+
+		path := "/@lens-vm/rename/-/rename-" + v + ".tgz"
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			srv.downloads++
+			w.Write(tgz)
+		})
+		versions[v] = map[string]interface{}{
+			"dist": map[string]string{
+				"tarball":   srv.URL + path,
+				"integrity": "sha512-" + base64.StdEncoding.EncodeToString(sum[:]),
+			},
+		}
+	}
+
+	mux.HandleFunc("/@lens-vm/rename", func(w http.ResponseWriter, r *http.Request) {
+		srv.lookups++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dist-tags": map[string]string{"latest": "1.4.1", "next": "2.0.0-rc.1"},
+			"versions":  versions,
+		})
+	})
+	return srv
+}
+
+func TestParseTarget(t *testing.T) {
+	name, rng, file, err := parseTarget("lens-vm-rename@1.4.1/dist/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lens-vm-rename", "1.4.1", "dist/rename.wasm"}, []string{name, rng, file})
+
+	name, rng, file, err = parseTarget("@lens-vm/rename")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@lens-vm/rename", "latest", "module.json"}, []string{name, rng, file})
+
+	_, _, _, err = parseTarget("@lens-vm")
+	assert.Error(t, err)
+}
+
+func TestNPMResolve(t *testing.T) {
+	srv := newRegistry(t, false)
+	defer srv.Close()
+
+	res := &NPMResolver{Registry: srv.URL}
+	assert.Equal(t, "npm", res.Scheme())
+
+	buf, err := res.Resolve(context.Background(), "npm://@lens-vm/rename@1.3.1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename", "version": "1.3.1"}`, string(buf))
+
+	buf, err = res.Resolve(context.Background(), "@lens-vm/rename@~1.3/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+
+	buf, err = res.Resolve(context.Background(), "@lens-vm/rename/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.4.1", string(buf))
+
+	buf, err = res.Resolve(context.Background(), "@lens-vm/rename@next/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 2.0.0-rc.1", string(buf))
+
+	_, err = res.Resolve(context.Background(), "@lens-vm/rename@^3")
+	assert.Error(t, err)
+}
+
+func TestNPMResolveConcrete(t *testing.T) {
+	srv := newRegistry(t, false)
+	defer srv.Close()
+
+	res := &NPMResolver{Registry: srv.URL}
+	buf, concrete, err := res.ResolveConcrete(context.Background(), "npm://@lens-vm/rename@~1.3/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+	assert.Equal(t, "@lens-vm/rename@1.3.1/rename.wasm", concrete)
+
+	_, concrete, err = res.ResolveConcrete(context.Background(), "@lens-vm/rename")
+	assert.NoError(t, err)
+	assert.Equal(t, "@lens-vm/rename@1.4.1/module.json", concrete)
+}
+
+func TestNPMResolveVersion(t *testing.T) {
+	srv := newRegistry(t, false)
+	defer srv.Close()
+
+	res := &NPMResolver{Registry: srv.URL}
+	buf, concrete, err := res.ResolveVersion(context.Background(), "npm://@lens-vm/rename/rename.wasm", "~1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+	assert.Equal(t, "@lens-vm/rename@1.3.1/rename.wasm", concrete)
+
+	_, concrete, err = res.ResolveVersion(context.Background(), "@lens-vm/rename@1.4.1", "^1")
+	assert.NoError(t, err)
+	assert.Equal(t, "@lens-vm/rename@1.4.1/module.json", concrete)
+
+	_, _, err = res.ResolveVersion(context.Background(), "@lens-vm/rename@1.4.1", "~1.3")
+	assert.Error(t, err)
+	_, _, err = res.ResolveVersion(context.Background(), "@lens-vm/rename@next", "^1")
+	assert.Error(t, err)
+}
+
+func TestNPMResolveOnce(t *testing.T) {
+	srv := newRegistry(t, false)
+	defer srv.Close()
+
+	// the module file and the wasm module of the package are
+	// resolved from a single lookup, and a single download
+	res := &NPMResolver{Registry: srv.URL}
+	buf, err := res.Resolve(context.Background(), "@lens-vm/rename@^1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename", "version": "1.4.1"}`, string(buf))
+	buf, err = res.Resolve(context.Background(), "@lens-vm/rename@^1.3/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.4.1", string(buf))
+	assert.Equal(t, 1, srv.lookups)
+	assert.Equal(t, 1, srv.downloads)
+}
+
+func TestVerifyTarball(t *testing.T) {
+	tgz := []byte("tarball")
+	sum := sha512.Sum512(tgz)
+	other := sha512.Sum512([]byte("other"))
+	shasum := sha1.Sum(tgz)
+
+	// the sha512 hashes are checked, and the legacy sha1 one ignored
+	var pv packageVersion
+	pv.Dist.Integrity = "sha1-" + base64.StdEncoding.EncodeToString(shasum[:]) +
+		" sha512-" + base64.StdEncoding.EncodeToString(other[:]) +
+		" sha512-" + base64.StdEncoding.EncodeToString(sum[:]) + "?opt"
+	assert.NoError(t, verifyTarball(tgz, pv))
+
+	pv.Dist.Integrity = "sha512-" + base64.StdEncoding.EncodeToString(other[:])
+	assert.Error(t, verifyTarball(tgz, pv))
+
+	// a malformed hash doesn't hide the mismatch of another one
+	pv.Dist.Integrity = "sha512-!! sha512-" + base64.StdEncoding.EncodeToString(other[:])
+	err := verifyTarball(tgz, pv)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Integrity mismatch")
+	}
+	pv.Dist.Integrity = "sha512-!!"
+	err = verifyTarball(tgz, pv)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Malformed integrity")
+	}
+
+	// without a supported hash, the shasum is checked
+	pv.Dist.Integrity = "sha1-" + base64.StdEncoding.EncodeToString(shasum[:])
+	pv.Dist.Shasum = hex.EncodeToString(shasum[:])
+	assert.NoError(t, verifyTarball(tgz, pv))
+	pv.Dist.Shasum = "abcd"
+	assert.Error(t, verifyTarball(tgz, pv))
+}
+
+func TestNPMResolveTampered(t *testing.T) {
+	srv := newRegistry(t, true)
+	defer srv.Close()
+
+	_, err := (&NPMResolver{Registry: srv.URL}).Resolve(context.Background(), "@lens-vm/rename@1.3.1")
+	assert.Error(t, err)
+}
+
+func TestNPMResolveCDN(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/lens-vm-rename@1.4.1/module.json", r.URL.Path)
+		w.Write([]byte(`{"name": "rename"}`))
+	}))
+	defer srv.Close()
+
+	buf, err := (&NPMResolver{CDN: srv.URL}).Resolve(context.Background(), "lens-vm-rename@1.4.1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename"}`, string(buf))
+}
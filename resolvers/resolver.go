@@ -13,9 +13,51 @@ type Resolver interface {
 	Resolve(ctx context.Context, target string) ([]byte, error)
 }
 
+// ConcreteResolver is a Resolver of targets which may change over
+// time, eg. with a version range or tag, which also returns the
+// concrete target the content was resolved from, eg. with the exact
+// version. Resolving the concrete target again returns the same
+// content, so it's what lock files pin.
+type ConcreteResolver interface {
+	Resolver
+	ResolveConcrete(ctx context.Context, target string) ([]byte, string, error)
+}
+
+// VersionResolver is a ConcreteResolver of versioned packages, eg. a
+// registry resolver, which selects the version of the target package
+// within a semver range. Without a version in the target, the highest
+// version in the range is selected, otherwise the version the target
+// selects must be in the range.
+type VersionResolver interface {
+	ConcreteResolver
+	ResolveVersion(ctx context.Context, target, rng string) ([]byte, string, error)
+}
+
+// ResolveVersion resolves the target with the resolver, within the
+// semver range if the resolver is a VersionResolver, and returns the
+// concrete target like ResolveConcrete. Other resolvers, or an empty
+// range, resolve the target as is.
+func ResolveVersion(ctx context.Context, r Resolver, target, rng string) ([]byte, string, error) {
+	if vr, ok := r.(VersionResolver); ok && rng != "" {
+		return vr.ResolveVersion(ctx, target, rng)
+	}
+	return ResolveConcrete(ctx, r, target)
+}
+
+// ResolveConcrete resolves the target with the resolver, and returns
+// the concrete target, which is the target itself unless the resolver
+// is a ConcreteResolver.
+func ResolveConcrete(ctx context.Context, r Resolver, target string) ([]byte, string, error) {
+	if cr, ok := r.(ConcreteResolver); ok {
+		return cr.ResolveConcrete(ctx, target)
+	}
+	buf, err := r.Resolve(ctx, target)
+	return buf, target, err
+}
+
 // Resolver Types
 // - File Get - file://
-// * HTTP Get - http://
-// * IPFS - ipfs://
-// * WebAssembly Package Manager (wapm.io) - wapm://
-// * NPN - npm:// (powered by unpkg.com)
+// - HTTP Get - http:// and https://
+// - IPFS - ipfs://
+// - WebAssembly Package Manager (wapm.io) - wapm://
+// - NPM - npm:// (registry tarballs, or unpkg.com)
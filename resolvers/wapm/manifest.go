@@ -0,0 +1,81 @@
+package wapm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// manifestModule is a [[module]] table of the package manifest
+type manifestModule struct {
+	name   string
+	source string
+}
+
+// parseManifest returns the modules of the wapm.toml package manifest.
+// It only parses the subset of TOML the module tables use, which is
+// key/value pairs of strings, and skips everything else.
+func parseManifest(buf []byte) []manifestModule {
+	var modules []manifestModule
+	inModule := false
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "["):
+			inModule = strings.Trim(line, "[] \t") == "module" && strings.HasPrefix(line, "[[")
+			if inModule {
+				modules = append(modules, manifestModule{})
+			}
+			continue
+		case !inModule:
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.Trim(strings.TrimSpace(parts[0]), `"'`)
+		value, ok := parseString(strings.TrimSpace(parts[1]))
+		if !ok {
+			continue
+		}
+
+		m := &modules[len(modules)-1]
+		switch key {
+		case "name":
+			m.name = value
+		case "source":
+			m.source = value
+		}
+	}
+	return modules
+}
+
+// parseString parses a basic or literal TOML string,
+// followed by an optional comment
+func parseString(s string) (string, bool) {
+	if strings.HasPrefix(s, "'") {
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", false
+		}
+		return s[1 : end+1], true
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return "", false
+	}
+
+	// find the closing quote, skipping escaped quotes
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			return v, err == nil
+		}
+	}
+	return "", false
+}
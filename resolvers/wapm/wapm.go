@@ -0,0 +1,302 @@
+package wapm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	httpresolver "github.com/lens-vm/lens-vm-go-host/resolvers/http"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/internal/tarball"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/internal/version"
+)
+
+// DefaultRegistryURL is the registry used when none
+// is given to the resolver.
+const DefaultRegistryURL = "https://registry.wapm.io"
+
+// DefaultFile is the file resolved from a
+// package when the URI has no path.
+const DefaultFile = "module.json"
+
+// ManifestFile is the package manifest, which
+// lists the wasm modules of the package.
+const ManifestFile = "wapm.toml"
+
+const packageVersionsQuery = `query ($name: String!) {
+	getPackage(name: $name) {
+		versions {
+			version
+			distribution {
+				downloadUrl
+			}
+		}
+	}
+}`
+
+// WAPMResolver resolves wapm://<namespace>/<name>[@<version>][/<path>]
+// URIs. The version can be an exact version, or a semver range, in
+// which case the highest matching version is used, and without one
+// the highest version is used. Without a path the
+// module.json file of the package is resolved. Otherwise, if the path
+// is the name of a module of the package manifest, its .wasm source
+// is resolved, else the file at the given path.
+//
+// The resolver selects the version of every package and range once,
+// and downloads the tarball of every package version once, so all
+// the files of a package are resolved from the same version. It must
+// be used through a pointer, and not be copied after its first use.
+type WAPMResolver struct {
+	// Registry is the base URL of the registry GraphQL API.
+	// If empty, DefaultRegistryURL is used.
+	Registry string
+
+	// Client is the client used to make requests.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// mu guards the selected versions, keyed by package and
+	// range, and the tarballs, keyed by package and version
+	mu       sync.Mutex
+	versions map[string]packageVersion
+	tarballs map[string][]byte
+}
+
+type packageVersion struct {
+	Version      string `json:"version"`
+	Distribution struct {
+		DownloadURL string `json:"downloadUrl"`
+	} `json:"distribution"`
+}
+
+func (w *WAPMResolver) Scheme() string {
+	return "wapm"
+}
+
+func (w *WAPMResolver) Resolve(ctx context.Context, target string) ([]byte, error) {
+	buf, _, err := w.ResolveConcrete(ctx, target)
+	return buf, err
+}
+
+// ResolveConcrete resolves the target like Resolve, and returns the
+// target of the exact version of the package it resolved.
+func (w *WAPMResolver) ResolveConcrete(ctx context.Context, target string) ([]byte, string, error) {
+	return w.ResolveVersion(ctx, target, "")
+}
+
+// ResolveVersion resolves the target like ResolveConcrete, with a
+// version within the given semver range, see resolvers.VersionResolver.
+// A target without a version selects the highest version in the range.
+func (w *WAPMResolver) ResolveVersion(ctx context.Context, target, within string) ([]byte, string, error) {
+	target = strings.TrimPrefix(target, w.Scheme()+"://")
+	name, rng, file, err := parseTarget(target)
+	if err != nil {
+		return nil, "", err
+	}
+	if rng == "*" && within != "" {
+		rng, within = within, ""
+	}
+
+	pv, err := w.selectVersion(ctx, name, rng, within)
+	if err != nil {
+		return nil, "", err
+	}
+	tgz, err := w.tarball(ctx, name, pv)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path := file
+	if file != DefaultFile {
+		if source, ok := moduleSource(tgz, file); ok {
+			path = source
+		}
+	}
+	buf, err := tarball.ReadFile(tgz, "", path)
+	if err != nil {
+		return nil, "", err
+	}
+	return buf, fmt.Sprintf("%s@%s/%s", name, pv.Version, file), nil
+}
+
+// tarball returns the tarball of the package version,
+// which is only downloaded once
+func (w *WAPMResolver) tarball(ctx context.Context, name string, pv packageVersion) ([]byte, error) {
+	key := name + "@" + pv.Version
+	w.mu.Lock()
+	tgz, ok := w.tarballs[key]
+	w.mu.Unlock()
+	if ok {
+		return tgz, nil
+	}
+
+	u := pv.Distribution.DownloadURL
+	res := httpresolver.HTTPResolver{Client: w.Client, Secure: httpresolver.IsSecure(u)}
+	tgz, err := res.Resolve(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tarballs == nil {
+		w.tarballs = make(map[string][]byte)
+	}
+	w.tarballs[key] = tgz
+	return tgz, nil
+}
+
+// moduleSource returns the source file of the named module
+// of the package manifest, if the package has a manifest
+// and it lists the module
+func moduleSource(tgz []byte, module string) (string, bool) {
+	buf, err := tarball.ReadFile(tgz, "", ManifestFile)
+	if err != nil {
+		return "", false
+	}
+	for _, m := range parseManifest(buf) {
+		if m.name == module && m.source != "" {
+			return m.source, true
+		}
+	}
+	return "", false
+}
+
+// parseTarget splits a <namespace>/<name>[@<version>][/<path>]
+// target into its parts. A missing version matches any version.
+func parseTarget(target string) (name, rng, file string, err error) {
+	rng = "*"
+	file = DefaultFile
+
+	// the package name is always two path segments
+	parts := strings.SplitN(target, "/", 3)
+	if len(parts) < 2 {
+		return "", "", "", fmt.Errorf("Malformed wapm package '%s'", target)
+	}
+	if len(parts) > 2 {
+		file = parts[2]
+	}
+	name = parts[0] + "/" + parts[1]
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, rng = name[:i], name[i+1:]
+	}
+	if parts[0] == "" || strings.HasSuffix(name, "/") || rng == "" || file == "" {
+		return "", "", "", fmt.Errorf("Malformed wapm package '%s'", target)
+	}
+	return name, rng, file, nil
+}
+
+// selectVersion returns the highest version of the named package
+// matching the given semver range, which must be within the other
+// range if any, and is only selected once.
+func (w *WAPMResolver) selectVersion(ctx context.Context, name, rng, within string) (packageVersion, error) {
+	key := name + "@" + rng
+	if within != "" {
+		key += " within " + within
+	}
+	w.mu.Lock()
+	pv, ok := w.versions[key]
+	w.mu.Unlock()
+	if ok {
+		return pv, nil
+	}
+
+	versions, err := w.packageVersions(ctx, name)
+	if err != nil {
+		return packageVersion{}, err
+	}
+
+	names := make([]string, len(versions))
+	for i, pv := range versions {
+		names[i] = pv.Version
+	}
+	selected, err := version.Highest(rng, names)
+	if err != nil {
+		return packageVersion{}, fmt.Errorf("Failed to select version of wapm package %s: %w", name, err)
+	}
+	if within != "" {
+		ok, err := version.Within(within, selected)
+		if err != nil {
+			return packageVersion{}, fmt.Errorf("Failed to select version of wapm package %s: %w", name, err)
+		}
+		if !ok {
+			return packageVersion{}, fmt.Errorf("wapm package %s@%s is not within '%s'", name, selected, within)
+		}
+	}
+
+	for _, pv := range versions {
+		if pv.Version != selected {
+			continue
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.versions == nil {
+			w.versions = make(map[string]packageVersion)
+		}
+		w.versions[key] = pv
+		return pv, nil
+	}
+	return packageVersion{}, fmt.Errorf("wapm package %s@%s not found", name, selected)
+}
+
+// packageVersions queries the registry for all the
+// published versions of the named package.
+func (w *WAPMResolver) packageVersions(ctx context.Context, name string) ([]packageVersion, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     packageVersionsQuery,
+		"variables": map[string]string{"name": name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	registry := w.Registry
+	if registry == "" {
+		registry = DefaultRegistryURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(registry, "/")+"/graphql", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to query wapm registry for %s: %s", name, resp.Status)
+	}
+
+	var result struct {
+		Data struct {
+			GetPackage *struct {
+				Versions []packageVersion `json:"versions"`
+			} `json:"getPackage"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("Failed to query wapm registry for %s: %s", name, result.Errors[0].Message)
+	}
+	if result.Data.GetPackage == nil {
+		return nil, fmt.Errorf("wapm package %s not found", name)
+	}
+	return result.Data.GetPackage.Versions, nil
+}
@@ -0,0 +1,229 @@
+package wapm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		assert.NoError(t, err)
+		_, err = tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// registry counts the queries and downloads of the registry
+type registry struct {
+	*httptest.Server
+	queries   int
+	downloads int
+}
+
+func newRegistry(t *testing.T) *registry {
+	mux := http.NewServeMux()
+	srv := &registry{Server: httptest.NewServer(mux)}
+
+	versions := []string{"1.2.0", "1.3.1", "1.4.0-beta", "2.0.0"}
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables map[string]string `json:"variables"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		srv.queries++
+		if req.Variables["name"] != "lens-vm/rename" {
+			w.Write([]byte(`{"data": {"getPackage": null}}`))
+			return
+		}
+
+		var pvs []map[string]interface{}
+		for _, v := range versions {
+			pvs = append(pvs, map[string]interface{}{
+				"version": v,
+				"distribution": map[string]string{
+					"downloadUrl": srv.URL + "/download/" + v + ".tar.gz",
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"getPackage": map[string]interface{}{"versions": pvs},
+			},
+		})
+	})
+	for _, v := range versions {
+		tgz := makeTarball(t, map[string]string{
+			"wapm.toml":     "[package]\nname = \"lens-vm/rename\"\n\n[[module]]\nname = \"rename\"\nsource = \"rename.wasm\"\n",
+			"module.json":   fmt.Sprintf(`{"name": "rename", "version": "%s"}`, v),
+			"./rename.wasm": "wasm " + v,
+		})
+		mux.HandleFunc("/download/"+v+".tar.gz", func(w http.ResponseWriter, r *http.Request) {
+			srv.downloads++
+			w.Write(tgz)
+		})
+	}
+	return srv
+}
+
+func TestWAPMResolve(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	res := &WAPMResolver{Registry: srv.URL}
+	assert.Equal(t, "wapm", res.Scheme())
+
+	buf, err := res.Resolve(context.Background(), "lens-vm/rename@1.3.1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename", "version": "1.3.1"}`, string(buf))
+
+	buf, err = res.Resolve(context.Background(), "wapm://lens-vm/rename@1.3.1/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+}
+
+func TestWAPMResolveRange(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	res := &WAPMResolver{Registry: srv.URL}
+	buf, err := res.Resolve(context.Background(), "lens-vm/rename@^1.2/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+
+	buf, err = res.Resolve(context.Background(), "lens-vm/rename")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename", "version": "2.0.0"}`, string(buf))
+
+	_, err = res.Resolve(context.Background(), "lens-vm/rename@^3.0")
+	assert.Error(t, err)
+}
+
+func TestParseTarget(t *testing.T) {
+	name, rng, file, err := parseTarget("lens-vm/rename@^1.2/dist/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lens-vm/rename", "^1.2", "dist/rename.wasm"}, []string{name, rng, file})
+
+	// without a version, the path isn't part of the package name
+	name, rng, file, err = parseTarget("lens-vm/rename/dist/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lens-vm/rename", "*", "dist/rename.wasm"}, []string{name, rng, file})
+
+	name, rng, file, err = parseTarget("lens-vm/rename")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lens-vm/rename", "*", "module.json"}, []string{name, rng, file})
+
+	for _, target := range []string{"rename", "rename@1.0.0", "/rename", "lens-vm/@1.0.0", "lens-vm/rename@", "lens-vm/rename/"} {
+		_, _, _, err = parseTarget(target)
+		assert.Error(t, err, target)
+	}
+}
+
+func TestWAPMResolveWithoutVersion(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	res := &WAPMResolver{Registry: srv.URL}
+	buf, concrete, err := res.ResolveConcrete(context.Background(), "wapm://lens-vm/rename/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 2.0.0", string(buf))
+	assert.Equal(t, "lens-vm/rename@2.0.0/rename.wasm", concrete)
+}
+
+func TestWAPMResolveVersion(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	res := &WAPMResolver{Registry: srv.URL}
+	buf, concrete, err := res.ResolveVersion(context.Background(), "lens-vm/rename@*/rename.wasm", "^1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+	assert.Equal(t, "lens-vm/rename@1.3.1/rename.wasm", concrete)
+
+	_, concrete, err = res.ResolveVersion(context.Background(), "lens-vm/rename@1.2.0", "^1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "lens-vm/rename@1.2.0/module.json", concrete)
+
+	_, _, err = res.ResolveVersion(context.Background(), "lens-vm/rename@2.0.0", "^1.2")
+	assert.Error(t, err)
+}
+
+func TestWAPMResolveMissing(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	res := &WAPMResolver{Registry: srv.URL}
+	_, err := res.Resolve(context.Background(), "lens-vm/missing@1.0.0")
+	assert.Error(t, err)
+
+	_, err = res.Resolve(context.Background(), "lens-vm/rename@1.3.1/missing.wasm")
+	assert.Error(t, err)
+}
+
+func TestWAPMResolveOnce(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	// the module file and the wasm module of the package are
+	// resolved from a single version, and a single download
+	res := &WAPMResolver{Registry: srv.URL}
+	buf, err := res.Resolve(context.Background(), "lens-vm/rename@^1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "rename", "version": "1.3.1"}`, string(buf))
+	buf, err = res.Resolve(context.Background(), "lens-vm/rename@^1.2/rename.wasm")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.3.1", string(buf))
+	assert.Equal(t, 1, srv.queries)
+	assert.Equal(t, 1, srv.downloads)
+}
+
+func TestWAPMResolveManifestModule(t *testing.T) {
+	srv := newRegistry(t)
+	defer srv.Close()
+
+	// the path names a module of the manifest
+	res := &WAPMResolver{Registry: srv.URL}
+	buf, concrete, err := res.ResolveConcrete(context.Background(), "wapm://lens-vm/rename@~1.2/rename")
+	assert.NoError(t, err)
+	assert.Equal(t, "wasm 1.2.0", string(buf))
+	assert.Equal(t, "lens-vm/rename@1.2.0/rename", concrete)
+}
+
+func TestParseManifest(t *testing.T) {
+	modules := parseManifest([]byte(`
+[package]
+name = "lens-vm/rename"
+version = "1.0.0"
+
+# the lens module
+[[module]]
+name = "rename"
+source = 'target/rename.wasm'
+abi = "wasi" # comment
+
+[[module]]
+"name" = "esc\"aped" # comment
+
+[[command]]
+name = "cmd"
+`))
+	assert.Equal(t, []manifestModule{
+		{name: "rename", source: "target/rename.wasm"},
+		{name: `esc"aped`},
+	}, modules)
+}
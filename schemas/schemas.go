@@ -0,0 +1,15 @@
+// Package schemas embeds the JSON schemas for the LensVM
+// module and lens definition files.
+package schemas
+
+import _ "embed"
+
+// Module is the JSON schema of a module.json file
+//
+//go:embed module-schema.json
+var Module []byte
+
+// Lens is the JSON schema of a lens.json file
+//
+//go:embed lens-schema.json
+var Lens []byte
@@ -3,12 +3,13 @@ package types
 import "encoding/json"
 
 type ModuleFile struct {
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
-	Url         string `json:"url,omitempty"`
-	Runtime     string `json:"runtime"`
-	Language    string `json:"language"`
-	Package     string `json:"package"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Url         string    `json:"url,omitempty"`
+	Runtime     string    `json:"runtime"`
+	Language    string    `json:"language"`
+	Version     string    `json:"version,omitempty"`
+	Package     Reference `json:"package"`
 
 	Import ImportDefinition `json:"import"`
 
@@ -32,27 +33,79 @@ type LensFile struct {
 	Lenses []map[string]*json.RawMessage `json:"lenses"`
 }
 
-type ImportDefinition map[string]string
+type ImportDefinition map[string]Reference
+
+// Reference is a reference to a module file or package. In JSON
+// it is either the plain URI string, or an object with the URI,
+// an optional SRI style integrity hash, and an optional semver
+// range of the referenced module, eg.
+// {"uri": "file://module.json", "integrity": "sha256-...", "version": "^1.2.0"}
+type Reference struct {
+	URI       string `json:"uri"`
+	Integrity string `json:"integrity,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+func (r *Reference) UnmarshalJSON(buf []byte) error {
+	var uri string
+	if err := json.Unmarshal(buf, &uri); err == nil {
+		*r = Reference{URI: uri}
+		return nil
+	}
+
+	// alias the type to avoid recursing into UnmarshalJSON
+	type reference Reference
+	var ref reference
+	if err := json.Unmarshal(buf, &ref); err != nil {
+		return err
+	}
+	*r = Reference(ref)
+	return nil
+}
+
+func (r Reference) MarshalJSON() ([]byte, error) {
+	if r.Integrity == "" && r.Version == "" {
+		return json.Marshal(r.URI)
+	}
+
+	type reference Reference
+	return json.Marshal(reference(r))
+}
 
 // type GenericDefinition map[string]*json.RawMessage
 
 type ResolvedModule struct {
-	ID           string
-	Name         string
-	Description  string
-	Url          string
-	Runtime      string
-	Language     string
-	PackagePath  string
-	PackageBytes []byte
+	ID               string
+	Name             string
+	Description      string
+	Url              string
+	Runtime          string
+	Language         string
+	Version          string
+	PackagePath      string
+	PackageIntegrity string
+	PackageBytes     []byte
+
+	// Integrity is the hash of the resolved module file
+	Integrity string
+
+	// ResolvedPath and PackageResolvedPath are the concrete URIs
+	// the module file and package were resolved from, which only
+	// differ from the ID and PackagePath if they have a version
+	// range or tag, see resolvers.ConcreteResolver
+	ResolvedPath        string
+	PackageResolvedPath string
 
 	Imports map[string]ImportedModule
 	Exports []ExportDefinition
 }
 
 type ImportedModule struct {
-	Path   string
-	Module ResolvedModule
+	Path      string
+	Integrity string
+	// Version is the semver range of the imported module, if any
+	Version string
+	Module  ResolvedModule
 }
 
 // ModuleToReolvedModule does a basic syntax translation
@@ -60,13 +113,15 @@ type ImportedModule struct {
 // the []Modules arrays in both
 func ModuleToResolvedModule(f ModuleFile) ResolvedModule {
 	return ResolvedModule{
-		Name:        f.Name,
-		Description: f.Description,
-		Url:         f.Url,
-		Runtime:     f.Runtime,
-		Language:    f.Language,
-		PackagePath: f.Package,
-		Exports:     f.Exports,
-		Imports:     make(map[string]ImportedModule),
+		Name:             f.Name,
+		Description:      f.Description,
+		Url:              f.Url,
+		Runtime:          f.Runtime,
+		Language:         f.Language,
+		Version:          f.Version,
+		PackagePath:      f.Package.URI,
+		PackageIntegrity: f.Package.Integrity,
+		Exports:          f.Exports,
+		Imports:          make(map[string]ImportedModule),
 	}
 }
@@ -0,0 +1,42 @@
+package types
+
+// LockFileVersion is the version of the lock file format
+const LockFileVersion = 1
+
+// LockFile pins the fully resolved import tree of a lens file,
+// so later loads resolve the exact same modules and content.
+type LockFile struct {
+	LockFileVersion int `json:"lockfileVersion"`
+
+	// Import maps the lens file import names
+	// to the IDs of the resolved modules
+	Import map[string]string `json:"import"`
+
+	// Modules maps the ID of every module in
+	// the import tree to its locked definition
+	Modules map[string]LockedModule `json:"modules"`
+}
+
+// LockedModule is the locked definition of a single module
+type LockedModule struct {
+	Version string `json:"version,omitempty"`
+
+	// Resolved is the concrete URI the module file is loaded
+	// from, if it differs from the module ID, eg. with the exact
+	// version instead of a version range or tag
+	Resolved string `json:"resolved,omitempty"`
+
+	// Integrity is the hash of the module file
+	Integrity string `json:"integrity"`
+
+	// Package is the URI and hash of the module package
+	Package Reference `json:"package"`
+
+	// PackageResolved is the concrete URI the package is
+	// loaded from, if it differs from the package URI
+	PackageResolved string `json:"packageResolved,omitempty"`
+
+	// Imports maps the module import names
+	// to the IDs of the resolved modules
+	Imports map[string]string `json:"imports,omitempty"`
+}
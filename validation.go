@@ -0,0 +1,187 @@
+package lensvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lens-vm/lens-vm-go-host/schemas"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var (
+	ErrInvalidArguments  = errors.New("invalid lens arguments")
+	ErrInvalidModuleFile = errors.New("invalid module file")
+	ErrInvalidLensFile   = errors.New("invalid lens file")
+
+	moduleSchema = mustSchema(schemas.Module)
+	lensSchema   = mustSchema(schemas.Lens)
+)
+
+// ValidationError is returned when a JSON document, eg.
+// a module file, a lens file, or the arguments of a lens,
+// doesn't match its JSON schema
+type ValidationError struct {
+	// Path to the invalid field, eg. (root).exports.0.name
+	Path string
+	// Rule is the schema rule that failed, eg. required
+	Rule string
+	// Description is a human readable description of the failure
+	Description string
+
+	// Err is either ErrInvalidModuleFile, ErrInvalidLensFile
+	// or ErrInvalidArguments
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", e.Err, e.Path, e.Description, e.Rule)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateModuleFile validates the raw JSON of a module
+// file against the module schema
+func ValidateModuleFile(buf []byte) error {
+	return validateSchema(moduleSchema, buf, ErrInvalidModuleFile)
+}
+
+// ValidateLensFile validates the raw JSON of a lens
+// file against the lens schema
+func ValidateLensFile(buf []byte) error {
+	return validateSchema(lensSchema, buf, ErrInvalidLensFile)
+}
+
+func validateSchema(schema *gojsonschema.Schema, buf []byte, kind error) error {
+	res, err := schema.Validate(gojsonschema.NewBytesLoader(buf))
+	if err != nil {
+		return fmt.Errorf("%w: %v", kind, err)
+	}
+	if res.Valid() {
+		return nil
+	}
+
+	resErr := res.Errors()[0]
+	return &ValidationError{
+		Path:        resErr.Context().String(),
+		Rule:        resErr.Type(),
+		Description: resErr.Description(),
+		Err:         kind,
+	}
+}
+
+func mustSchema(buf []byte) *gojsonschema.Schema {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(buf))
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// ArgumentError is returned when the arguments of a lens entry
+// in the LensFile don't match the arguments JSON schema of the
+// exported lens function. It unwraps to its ValidationError.
+type ArgumentError struct {
+	// Index of the lens entry in the LensFile
+	Index int
+	// Lens is the name of the lens function
+	Lens string
+
+	ValidationError
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("Invalid arguments for lens '%s' at index %d: %s: %s (%s)", e.Lens, e.Index, e.Path, e.Description, e.Rule)
+}
+
+func (e *ArgumentError) Unwrap() error {
+	return &e.ValidationError
+}
+
+// validateLensArguments validates the arguments of every lens entry
+// in the loaded LensFile against the schema of its lens function.
+// Invalid and not imported lens entries fail with an *ExecError,
+// like they do on Exec.
+func (vm *VM) validateLensArguments() error {
+	for i, lens := range vm.lensFile.Lenses {
+		name, args, err := lensEntry(lens)
+		if err != nil {
+			return &ExecError{Index: i, Err: err}
+		}
+
+		mod, ok := vm.lensImports[name]
+		if !ok {
+			return &ExecError{Lens: name, Index: i, Err: ErrLensNotImported}
+		}
+
+		if err := mod.validateArguments(i, name, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateArguments validates the given arguments against the arguments
+// schema of the named lens function. Lens functions without a schema
+// accept any arguments.
+func (mod *Module) validateArguments(index int, name string, args []byte) error {
+	schema, err := mod.argumentSchema(name)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	if args == nil {
+		args = []byte("null")
+	}
+	// arguments which can't be validated at
+	// all, eg. malformed JSON, are invalid too
+	res, err := schema.Validate(gojsonschema.NewBytesLoader(args))
+	if err != nil {
+		return &ArgumentError{
+			Index: index,
+			Lens:  name,
+			ValidationError: ValidationError{
+				Path:        "(root)",
+				Rule:        "invalid_json",
+				Description: err.Error(),
+				Err:         ErrInvalidArguments,
+			},
+		}
+	}
+	if res.Valid() {
+		return nil
+	}
+
+	resErr := res.Errors()[0]
+	return &ArgumentError{
+		Index: index,
+		Lens:  name,
+		ValidationError: ValidationError{
+			Path:        resErr.Context().String(),
+			Rule:        resErr.Type(),
+			Description: resErr.Description(),
+			Err:         ErrInvalidArguments,
+		},
+	}
+}
+
+// argumentSchema returns the compiled arguments schema of the
+// named lens function, or nil if it doesn't define one.
+func (mod *Module) argumentSchema(name string) (*gojsonschema.Schema, error) {
+	if schema, ok := mod.argSchemas[name]; ok {
+		return schema, nil
+	}
+
+	raw := mod.exportArgs[name]
+	if raw == nil {
+		return nil, nil
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(*raw))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid arguments schema for lens '%s' in module %s: %w", name, mod.id, err)
+	}
+	mod.argSchemas[name] = schema
+	return schema, nil
+}
@@ -0,0 +1,125 @@
+package lensvm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLensArguments(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/strict/lens.json"))
+	assert.NoError(t, err)
+}
+
+func TestValidateLensArgumentsInvalid(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/invalid/lens.json"))
+	assert.True(t, errors.Is(err, ErrInvalidArguments))
+
+	var argErr *ArgumentError
+	if assert.True(t, errors.As(err, &argErr)) {
+		assert.Equal(t, 1, argErr.Index)
+		assert.Equal(t, "rename", argErr.Lens)
+		assert.Equal(t, "required", argErr.Rule)
+		assert.Equal(t, "(root)", argErr.Path)
+	}
+}
+
+func TestValidateLensArgumentsNotImported(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/notimported/lens.json"))
+	assert.True(t, errors.Is(err, ErrLensNotImported))
+
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, 1, execErr.Index)
+		assert.Equal(t, "missing", execErr.Lens)
+	}
+
+	// an entry must define exactly one lens function
+	vm.lensFile.Lenses = append(vm.lensFile.Lenses, map[string]*json.RawMessage{})
+	vm.lensFile.Lenses[1] = vm.lensFile.Lenses[0]
+	err = vm.validateLensArguments()
+	assert.True(t, errors.Is(err, ErrInvalidLensEntry))
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, 2, execErr.Index)
+		assert.Empty(t, execErr.Lens)
+	}
+}
+
+func TestValidateArgumentsAdditionalProperty(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/strict/lens.json"))
+	assert.NoError(t, err)
+
+	mod := vm.lensImports["rename"]
+	err = mod.validateArguments(0, "rename", []byte(`{"source": "a", "destination": "b", "extra": 1}`))
+	var argErr *ArgumentError
+	if assert.True(t, errors.As(err, &argErr)) {
+		assert.Equal(t, "additional_property_not_allowed", argErr.Rule)
+	}
+
+	err = mod.validateArguments(0, "rename", []byte(`{"source": 1, "destination": "b"}`))
+	if assert.True(t, errors.As(err, &argErr)) {
+		assert.Equal(t, "(root).source", argErr.Path)
+		assert.Equal(t, "invalid_type", argErr.Rule)
+	}
+
+	err = mod.validateArguments(2, "rename", []byte(`{"source":`))
+	assert.True(t, errors.Is(err, ErrInvalidArguments))
+	if assert.True(t, errors.As(err, &argErr)) {
+		assert.Equal(t, 2, argErr.Index)
+		assert.Equal(t, "rename", argErr.Lens)
+		assert.Equal(t, "invalid_json", argErr.Rule)
+	}
+}
+
+func TestValidateModuleFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/multi/module.json")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateModuleFile(buf))
+
+	buf, err = ioutil.ReadFile("testdata/integrity/module.json")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateModuleFile(buf))
+
+	err = ValidateModuleFile([]byte(`{"runtime": "wasm", "language": "go", "package": "file://main.wasm", "exports": []}`))
+	var valErr *ValidationError
+	if assert.True(t, errors.As(err, &valErr)) {
+		assert.True(t, errors.Is(err, ErrInvalidModuleFile))
+		assert.Equal(t, "(root).exports", valErr.Path)
+		assert.Equal(t, "array_min_items", valErr.Rule)
+	}
+
+	err = ValidateModuleFile([]byte(`{"runtime": "wasm", "language": "go", "package": {"uri": "file://main.wasm", "integrity": "md5-abc"}, "exports": [{"name": "rename"}]}`))
+	assert.True(t, errors.Is(err, ErrInvalidModuleFile))
+}
+
+func TestResolveInvalidModule(t *testing.T) {
+	vm := NewVM(nil)
+	_, err := vm.ResolveModule("file://testdata/invalid/module.json")
+
+	var valErr *ValidationError
+	if assert.True(t, errors.As(err, &valErr)) {
+		assert.Equal(t, "(root).exports.0", valErr.Path)
+		assert.Equal(t, "required", valErr.Rule)
+	}
+}
+
+func TestValidateLensFile(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/lens/simple/lens.json")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateLensFile(buf))
+
+	err = ValidateLensFile([]byte(`{"import": {}, "lenses": [{"rename": {}, "copy": {}}]}`))
+	var valErr *ValidationError
+	if assert.True(t, errors.As(err, &valErr)) {
+		assert.True(t, errors.Is(err, ErrInvalidLensFile))
+		assert.Equal(t, "(root).lenses.0", valErr.Path)
+		assert.Equal(t, "array_max_properties", valErr.Rule)
+	}
+}
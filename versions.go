@@ -0,0 +1,258 @@
+package lensvm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lens-vm/lens-vm-go-host/types"
+
+	"github.com/Masterminds/semver"
+)
+
+var (
+	ErrInvalidVersion  = errors.New("Invalid module version")
+	ErrVersionMismatch = errors.New("Module version doesn't match the import range")
+	ErrVersionConflict = errors.New("Module version conflict")
+)
+
+// ConflictPolicy is how a VM resolves a version conflict, when
+// the importers of a module need versions which no single
+// version of the module is compatible with.
+type ConflictPolicy int
+
+const (
+	// ConflictSideBySide loads the conflicting versions side
+	// by side, so every importer links to its own version
+	ConflictSideBySide ConflictPolicy = iota
+	// ConflictError fails the load with a *VersionConflictError
+	ConflictError
+)
+
+// VersionRequirement is an import of a module, with the
+// semver range the importer requires
+type VersionRequirement struct {
+	// Importer is the ID of the importing
+	// module, empty for the LensFile
+	Importer string
+	// Import is the name of the imported lens function
+	Import string
+	// Range is the semver range of the import, empty if any
+	// version of the module is compatible
+	Range string
+	// Module is the ID of the module the import resolved
+	// to, and Version its version
+	Module  string
+	Version string
+}
+
+func (r VersionRequirement) String() string {
+	importer := "the lens file"
+	if r.Importer != "" {
+		importer = r.Importer
+	}
+	rng := r.Range
+	if rng == "" {
+		rng = "*"
+	}
+	return fmt.Sprintf("'%s' of %s requires %s (resolved %s)", r.Import, importer, rng, r.Version)
+}
+
+// VersionConflictError is returned when no single version of a
+// module is compatible with all of its importers, and the
+// conflict policy is ConflictError
+type VersionConflictError struct {
+	// Name is the name of the module
+	Name string
+	// Requirements are all the imports of the module
+	Requirements []VersionRequirement
+}
+
+func (e *VersionConflictError) Error() string {
+	reqs := make([]string, len(e.Requirements))
+	for i, r := range e.Requirements {
+		reqs[i] = r.String()
+	}
+	return fmt.Sprintf("%s: no version of %s matches all the imports: %s", ErrVersionConflict, e.Name, strings.Join(reqs, ", "))
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// resolveVersions checks the imported modules of the lens against
+// the semver ranges of their imports. Imports of differently versioned
+// modules with the same name are all linked to the highest version
+// which is compatible with every import. If there is none, the
+// conflict policy either keeps them side by side, or fails.
+//
+// It only picks among the modules the imports already resolved to.
+// Each import was resolved within its own range by the resolver of its
+// URI, if it's a resolvers.VersionResolver, eg. a registry resolver,
+// so a range never resolves another URI here. Superseded modules stay imported, so
+// the lock file still pins them, but nothing links to them, so they
+// are never compiled nor initialized.
+func (vm *VM) resolveVersions(lens types.LensFile) error {
+	reqs, err := vm.versionRequirements(lens)
+	if err != nil {
+		return err
+	}
+
+	// group the requirements by module name, modules
+	// without a name can't be told apart
+	byName := make(map[string][]VersionRequirement)
+	for _, req := range reqs {
+		name := vm.moduleImports[req.Module].definition.Name
+		if name != "" {
+			byName[name] = append(byName[name], req)
+		}
+	}
+	for _, name := range sortedKeys(byName) {
+		reqs := byName[name]
+		if !requiresVersions(reqs) {
+			continue
+		}
+
+		mod := vm.compatibleModule(reqs)
+		if mod == nil {
+			if vm.versionConflicts == ConflictError {
+				return &VersionConflictError{Name: name, Requirements: reqs}
+			}
+			continue
+		}
+
+		for _, req := range reqs {
+			if req.Importer == "" {
+				vm.setLensImport(req.Import, mod)
+			} else {
+				vm.moduleImports[req.Importer].setLensImport(req.Import, mod)
+			}
+		}
+	}
+	return nil
+}
+
+// versionRequirements returns the imports of the LensFile and of
+// all the modules, in order, after checking that every imported
+// module matches the range of its import.
+func (vm *VM) versionRequirements(lens types.LensFile) ([]VersionRequirement, error) {
+	var reqs []VersionRequirement
+	add := func(importer, name, rng, path string) error {
+		mod, ok := vm.moduleImports[path]
+		if !ok {
+			return nil
+		}
+		req := VersionRequirement{
+			Importer: importer,
+			Import:   name,
+			Range:    rng,
+			Module:   path,
+			Version:  mod.definition.Version,
+		}
+		if rng != "" {
+			ok, err := matchesVersion(rng, req.Version)
+			if err != nil {
+				return resolveError(path, err)
+			}
+			if !ok {
+				return resolveError(path, fmt.Errorf("%w: %s", ErrVersionMismatch, req))
+			}
+		}
+		reqs = append(reqs, req)
+		return nil
+	}
+
+	for _, name := range sortedKeys(lens.Import) {
+		ref := lens.Import[name]
+		if err := add("", name, ref.Version, ref.URI); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range sortedKeys(vm.moduleImports) {
+		imports := vm.moduleImports[id].definition.Imports
+		for _, name := range sortedKeys(imports) {
+			imp := imports[name]
+			if err := add(id, name, imp.Version, imp.Path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return reqs, nil
+}
+
+// requiresVersions reports if the requirements are
+// for more than one module, and have any ranges
+func requiresVersions(reqs []VersionRequirement) bool {
+	var ranged bool
+	modules := make(map[string]bool)
+	for _, req := range reqs {
+		modules[req.Module] = true
+		ranged = ranged || req.Range != ""
+	}
+	return ranged && len(modules) > 1
+}
+
+// compatibleModule returns the highest version of the required modules
+// which exports the imported lens functions, and matches the ranges
+// of all the requirements, or nil if there is none. Modules without
+// a valid version are never compatible.
+func (vm *VM) compatibleModule(reqs []VersionRequirement) *Module {
+	var best *Module
+	var bestVersion *semver.Version
+	for _, id := range sortedModuleIDs(reqs) {
+		mod := vm.moduleImports[id]
+		v, err := semver.NewVersion(mod.definition.Version)
+		if err != nil {
+			continue
+		}
+
+		compatible := true
+		for _, req := range reqs {
+			if !moduleHasLensFunc(mod.definition, req.Import) {
+				compatible = false
+				break
+			}
+			if req.Range == "" {
+				continue
+			}
+			if ok, _ := matchesVersion(req.Range, mod.definition.Version); !ok {
+				compatible = false
+				break
+			}
+		}
+
+		if compatible && (bestVersion == nil || v.GreaterThan(bestVersion)) {
+			best = mod
+			bestVersion = v
+		}
+	}
+	return best
+}
+
+func sortedModuleIDs(reqs []VersionRequirement) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, req := range reqs {
+		if !seen[req.Module] {
+			seen[req.Module] = true
+			ids = append(ids, req.Module)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// matchesVersion reports if the version matches the semver range
+func matchesVersion(rng, version string) (bool, error) {
+	constraint, err := semver.NewConstraint(rng)
+	if err != nil {
+		return false, fmt.Errorf("Invalid version range '%s': %w", rng, err)
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false, fmt.Errorf("%w '%s': %v", ErrInvalidVersion, version, err)
+	}
+	return constraint.Check(v), nil
+}
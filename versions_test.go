@@ -0,0 +1,146 @@
+package lensvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/lens-vm/lens-vm-go-host/resolvers"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/file"
+	"github.com/lens-vm/lens-vm-go-host/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	rename10 = "file://testdata/versions/rename-1.0/module.json"
+	rename13 = "file://testdata/versions/rename-1.3/module.json"
+	rename20 = "file://testdata/versions/rename-2.0/module.json"
+	extract  = "file://testdata/versions/extract/module.json"
+)
+
+func TestResolveVersionsCompatible(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/versions/lens.json"))
+	assert.NoError(t, err)
+
+	// extract requires ^1.0.0, and the lens ^1.2.0,
+	// so both are linked to rename 1.3.0
+	assert.Equal(t, rename13, vm.lensImports["rename"].id)
+	assert.Equal(t, rename13, vm.moduleImports[extract].dependancies["rename"].id)
+
+	// rename 1.0.0 is superseded, so it's never compiled
+	assert.Contains(t, vm.moduleImports, rename10)
+	assert.Nil(t, vm.moduleImports[rename10].wmod)
+	assert.NotNil(t, vm.moduleImports[rename13].wmod)
+
+	deps, err := vm.dgraph.SortOrder(extract)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rename13, extract}, deps)
+}
+
+func TestResolveVersionsSideBySide(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/versionconflict/lens.json"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, rename20, vm.lensImports["rename"].id)
+	assert.Equal(t, rename10, vm.moduleImports[extract].dependancies["rename"].id)
+}
+
+func TestResolveVersionsConflict(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers:        DefaultOptions.Resolvers,
+		VersionConflicts: ConflictError,
+	})
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/versionconflict/lens.json"))
+	assert.True(t, errors.Is(err, ErrVersionConflict))
+
+	var conflictErr *VersionConflictError
+	if assert.True(t, errors.As(err, &conflictErr)) {
+		assert.Equal(t, "rename", conflictErr.Name)
+		assert.Equal(t, []VersionRequirement{
+			{Import: "rename", Range: "^2.0.0", Module: rename20, Version: "2.0.0"},
+			{Importer: extract, Import: "rename", Range: "^1.0.0", Module: rename10, Version: "1.0.0"},
+		}, conflictErr.Requirements)
+	}
+	assert.Equal(t, "Module version conflict: no version of rename matches all the imports: "+
+		"'rename' of the lens file requires ^2.0.0 (resolved 2.0.0), "+
+		"'rename' of "+extract+" requires ^1.0.0 (resolved 1.0.0)", err.Error())
+}
+
+func TestResolveVersionsMismatch(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/versionmismatch/lens.json"))
+	assert.True(t, errors.Is(err, ErrVersionMismatch))
+
+	var resErr *ResolveError
+	if assert.True(t, errors.As(err, &resErr)) {
+		assert.Equal(t, rename10, resErr.URI)
+	}
+}
+
+// rangeResolver resolves files, and records the
+// range every target is resolved within
+type rangeResolver struct {
+	file.FileResolver
+	ranges map[string]string
+}
+
+func (r rangeResolver) Scheme() string {
+	return "ranged"
+}
+
+func (r rangeResolver) ResolveConcrete(ctx context.Context, target string) ([]byte, string, error) {
+	return r.ResolveVersion(ctx, target, "")
+}
+
+func (r rangeResolver) ResolveVersion(ctx context.Context, target, rng string) ([]byte, string, error) {
+	r.ranges[target] = rng
+	buf, err := r.Resolve(ctx, target)
+	return buf, target, err
+}
+
+func TestResolveVersionsWithinRange(t *testing.T) {
+	res := rangeResolver{ranges: make(map[string]string)}
+	vm := NewVM(&Options{
+		Resolvers: []resolvers.Resolver{file.FileResolver{}, res},
+	})
+
+	// the range of the reference is passed on to the resolver
+	// of the module file, the package has no range of its own
+	mod, err := vm.resolveModuleFile(context.Background(), make(map[string]bool), types.Reference{
+		URI:     "ranged://testdata/versions/rename-1.3/module.json",
+		Version: "^1.2.0",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.3.0", mod.Version)
+	assert.Equal(t, map[string]string{"testdata/versions/rename-1.3/module.json": "^1.2.0"}, res.ranges)
+}
+
+func TestMatchesVersion(t *testing.T) {
+	ok, err := matchesVersion("^1.2.0", "1.3.0")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = matchesVersion("~1.2.0", "1.3.0")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = matchesVersion("^1.2.0", "")
+	assert.True(t, errors.Is(err, ErrInvalidVersion))
+
+	_, err = matchesVersion("not a range", "1.0.0")
+	assert.Error(t, err)
+}
+
+func TestReferenceVersionJSON(t *testing.T) {
+	var ref types.Reference
+	err := json.Unmarshal([]byte(`{"uri": "file://rename/module.json", "version": "^1.2.0"}`), &ref)
+	assert.NoError(t, err)
+	assert.Equal(t, types.Reference{URI: "file://rename/module.json", Version: "^1.2.0"}, ref)
+
+	buf, err := json.Marshal(ref)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"uri": "file://rename/module.json", "version": "^1.2.0"}`, string(buf))
+}
@@ -2,19 +2,23 @@ package lensvm
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/lens-vm/lens-vm-go-host/engine"
 	"github.com/lens-vm/lens-vm-go-host/resolvers"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/cache"
 	"github.com/lens-vm/lens-vm-go-host/resolvers/file"
 	"github.com/lens-vm/lens-vm-go-host/types"
 	stypes "github.com/lens-vm/lens-vm-go-sdk/types"
 
-	"github.com/wasmerio/wasmer-go/wasmer"
+	"github.com/xeipuuv/gojsonschema"
 )
 
 var (
@@ -23,8 +27,21 @@ var (
 			file.FileResolver{},
 		},
 	}
+
+	ErrNilResolver             = errors.New("Resolver is nil")
+	ErrEmptyResolverScheme     = errors.New("Resolver is missing a scheme, cannot be empty")
+	ErrDuplicateResolverScheme = errors.New("Duplicate resolver scheme")
+	ErrNegativeOption          = errors.New("Option cannot be negative")
+	ErrModuleCacheWithEngine   = errors.New("Module cache dir only applies to the default engine, configure the cache of the given engine instead")
 )
 
+// localSchemes are the schemes of the resolvers which read
+// local content, which the resolver cache doesn't wrap, as
+// it's as cheap to read, and must not go stale
+var localSchemes = map[string]bool{
+	"file": true,
+}
+
 type Module struct {
 	vm *VM
 
@@ -33,11 +50,35 @@ type Module struct {
 
 	dependancies map[string]*Module
 	exportArgs   map[string]*json.RawMessage
+	argSchemas   map[string]*gojsonschema.Schema
 	// lenses       map[string]*Module
 
-	importObject *wasmer.ImportObject
-	wmod         *wasmer.Module
-	winst        *wasmer.Instance
+	// hostFuncs are the host functions registered
+	// on the module, see Module.RegisterFunc
+	hostFuncs []*hostFunc
+
+	// funcImports are the functions imported by the
+	// wasm module, keyed by their namespace
+	funcImports map[string]map[string]bool
+
+	imports engine.Imports
+	wmod    engine.Module
+	winst   engine.Instance
+	wmem    engine.Memory
+
+	// wfuel is the fuel global of the
+	// instance, if the module is metered
+	wfuel engine.Global
+
+	// pages is the number of pages of memory the
+	// instance reserved from the memory budget
+	pages uint32
+
+	// scratch region of guest memory used for host
+	// allocations, see Module.allocate
+	scratchBase int32
+	scratchSize int32
+	scratchUsed int32
 
 	initialized bool
 }
@@ -55,6 +96,67 @@ func (mod *Module) setLensImport(name string, target *Module) {
 type Options struct {
 	Resolvers     []resolvers.Resolver
 	ContextValues ContextValueOptions
+
+	// ResolverCache if set, wraps all the resolvers, except
+	// the ones of local schemes such as file, with an on disk
+	// resolution cache. Entries expire after the TTL of the
+	// cache, see cache.Options, and can be removed earlier
+	// with VM.EvictResolverCache and VM.PruneResolverCache.
+	ResolverCache *cache.Options
+
+	// Engine is the WebAssembly engine used to compile
+	// and run the lens modules. If nil, wasmer is used
+	// when cgo is enabled, and wazero otherwise.
+	Engine engine.Engine
+
+	// ModuleCacheDir if set, is the directory the default
+	// engine caches compiled modules in, so they are only
+	// compiled once, across VMs and processes. It can't be
+	// set along with Engine.
+	ModuleCacheDir string
+
+	// PoolSize if set, is the maximum number of instances
+	// of the module graph used to run concurrent Exec calls.
+	// When all of them are busy, Exec waits for one to be
+	// released. If zero, Exec must not be called concurrently.
+	PoolSize int
+
+	// Fuel if set, is the fuel budget of every Exec call. Fuel
+	// is consumed by running guest code, and Exec fails with
+	// ErrFuelExhausted when it runs out. The modules are
+	// instrumented with fuel metering, see engine.Meter.
+	Fuel uint64
+
+	// ExecTimeout if set, is the maximum duration of every Exec
+	// call, after which it fails with ErrExecTimeout. It also
+	// bounds the start function of every module instance. If
+	// the engine can't interrupt calls, eg. wasmer, it requires
+	// Fuel, which stops the abandoned calls. The timed out Exec
+	// then only returns once the abandoned call stopped, as the
+	// instances it ran on are replaced after it.
+	ExecTimeout time.Duration
+
+	// MaxMemoryPages if set, is the maximum size of the linear
+	// memory of every module instance, in 64KiB pages. Growing
+	// the memory past it fails in the guest.
+	MaxMemoryPages uint32
+
+	// MemoryBudgetPages if set, is the maximum total size of the
+	// linear memory of all the module instances of the VM, in
+	// 64KiB pages. With an instance pool, the budget is shared
+	// by the instances of the VM and of all the pool workers.
+	// It is checked whenever an instance grows its memory, so
+	// a growth past it fails in the guest, like MaxMemoryPages.
+	MemoryBudgetPages uint32
+
+	// WASI configures the WASI environment of the modules.
+	// If nil, the modules get an empty WASI environment.
+	WASI *WASIOptions
+
+	// VersionConflicts is how version conflicts between the
+	// importers of a module are resolved. The default loads
+	// the conflicting versions side by side.
+	VersionConflicts ConflictPolicy
 }
 
 // ContextValueOptions is an option struct
@@ -86,9 +188,7 @@ type VM struct {
 	// lens function from a module
 	lensImports map[string]*Module
 
-	wengine *wasmer.Engine
-	wstore  *wasmer.Store
-	wasiEnv *wasmer.WasiEnvironment
+	engine engine.Engine
 
 	resolvers map[string]resolvers.Resolver
 
@@ -97,51 +197,188 @@ type VM struct {
 
 	dgraph *dependancyGraph
 
+	// lock is the lock file the lens was loaded
+	// with, if any
+	lock *types.LockFile
+
 	buffers map[stypes.BufferType][]byte
 
+	// pool of workers for concurrent Exec calls,
+	// created on Init if poolSize is set. It's
+	// swapped atomically, as Close may be called
+	// concurrently with Exec
+	pool     atomic.Pointer[instancePool]
+	poolSize int
+
+	// fuel and execTimeout are the limits
+	// of every exec call, if set
+	fuel        uint64
+	execTimeout time.Duration
+
+	// maxMemoryPages is the memory limit of every module instance,
+	// and budget of all of them, shared with the pool workers, if set
+	maxMemoryPages uint32
+	budget         *memoryBudget
+
+	// memoryDenied is set when the memory of a module
+	// instance can't grow, during the current exec call
+	memoryDenied bool
+
+	// hostErr is the cause of the last internal failure of
+	// a host ABI function, during the current exec call
+	hostErr error
+
+	// wasi is the WASI environment of the
+	// module instances, nil if disabled
+	wasi *engine.WASI
+
+	versionConflicts ConflictPolicy
+
+	// hostFuncs are the host functions registered
+	// on the VM, see VM.RegisterFunc
+	hostFuncs []*hostFunc
+
 	initialized bool
 }
 
+// NewVM creates a new VM with the given options, or the
+// DefaultOptions if nil. It panics if the options are
+// invalid, see NewVMWithError.
 func NewVM(opt *Options) *VM {
+	vm, err := NewVMWithError(opt)
+	if err != nil {
+		panic(err)
+	}
+	return vm
+}
+
+// NewVMWithError creates a new VM with the given options,
+// or the DefaultOptions if nil. If the options are invalid,
+// it returns an *OptionsError.
+func NewVMWithError(opt *Options) (*VM, error) {
 	if opt == nil {
 		opt = DefaultOptions
 	}
-	wengine := wasmer.NewEngine()
-	wstore := wasmer.NewStore(wengine)
-	env, err := wasmer.NewWasiStateBuilder("lensvm-go-host").Finalize()
-	if err != nil {
-		panic(err)
+	if opt.PoolSize < 0 {
+		return nil, &OptionsError{Option: "PoolSize", Err: ErrNegativeOption}
+	}
+	if opt.ExecTimeout < 0 {
+		return nil, &OptionsError{Option: "ExecTimeout", Err: ErrNegativeOption}
+	}
+	if opt.VersionConflicts != ConflictSideBySide && opt.VersionConflicts != ConflictError {
+		return nil, &OptionsError{Option: "VersionConflicts", Err: fmt.Errorf("Unknown conflict policy %d", opt.VersionConflicts)}
+	}
+
+	if opt.Engine != nil && opt.ModuleCacheDir != "" {
+		return nil, &OptionsError{Option: "ModuleCacheDir", Err: ErrModuleCacheWithEngine}
+	}
+	eng := opt.Engine
+	if eng == nil {
+		var err error
+		eng, err = defaultEngine(opt)
+		if err != nil {
+			return nil, &OptionsError{Option: "Engine", Err: err}
+		}
+	}
+	if opt.ExecTimeout > 0 && opt.Fuel == 0 && !eng.Supports(engine.FeatureInterrupt) {
+		return nil, &OptionsError{Option: "ExecTimeout", Err: ErrTimeoutNeedsFuel}
+	}
+	if opt.WASI != nil && len(opt.WASI.ReadOnlyDirs) > 0 && !eng.Supports(engine.FeatureReadOnlyDirs) {
+		return nil, &OptionsError{Option: "WASI.ReadOnlyDirs", Err: fmt.Errorf("%w: read-only preopened directories", ErrUnsupported)}
 	}
 	vm := &VM{
-		wengine:       wengine,
-		wstore:        wstore,
-		wasiEnv:       env,
-		moduleImports: make(map[string]*Module),
-		lensImports:   make(map[string]*Module),
-		resolvers:     make(map[string]resolvers.Resolver),
+		engine:           eng,
+		moduleImports:    make(map[string]*Module),
+		lensImports:      make(map[string]*Module),
+		resolvers:        make(map[string]resolvers.Resolver),
+		buffers:          make(map[stypes.BufferType][]byte),
+		poolSize:         opt.PoolSize,
+		fuel:             opt.Fuel,
+		execTimeout:      opt.ExecTimeout,
+		maxMemoryPages:   opt.MaxMemoryPages,
+		budget:           newMemoryBudget(opt.MemoryBudgetPages),
+		wasi:             opt.WASI.engineWASI(),
+		versionConflicts: opt.VersionConflicts,
 	}
 
-	vm.initResolvers(opt.Resolvers)
-	return vm
+	if err := vm.initResolvers(opt.Resolvers, opt.ResolverCache); err != nil {
+		return nil, err
+	}
+	return vm, nil
 }
 
 // initResolvers sets up all the given resolvers into the
-// internal resolver map on the VM instance
-func (vm *VM) initResolvers(res []resolvers.Resolver) {
-	for _, r := range res {
+// internal resolver map on the VM instance, optionally
+// wrapping the ones of remote schemes with a resolution cache.
+func (vm *VM) initResolvers(res []resolvers.Resolver, cacheOpts *cache.Options) error {
+	for i, r := range res {
+		if r == nil {
+			return &OptionsError{Option: "Resolvers", Err: fmt.Errorf("%w: index %d", ErrNilResolver, i)}
+		}
+
 		scheme := r.Scheme()
 		if scheme == "" {
-			panic("Resolver is missing a scheme, cannot be empty")
+			return &OptionsError{Option: "Resolvers", Err: fmt.Errorf("%w: index %d", ErrEmptyResolverScheme, i)}
 		}
 
 		if _, exists := vm.resolvers[scheme]; exists {
-			panic("Duplicate resolver scheme")
+			return &OptionsError{Option: "Resolvers", Err: fmt.Errorf("%w: %s", ErrDuplicateResolverScheme, scheme)}
+		}
+
+		if cacheOpts != nil && !localSchemes[scheme] {
+			cached, err := cache.New(r, *cacheOpts)
+			if err != nil {
+				return &OptionsError{Option: "ResolverCache", Err: err}
+			}
+			r = cached
 		}
 		vm.resolvers[scheme] = r
 	}
+	return nil
+}
+
+// EvictResolverCache removes the entry for the given URI from
+// the resolver cache, so it's resolved again on the next load.
+// It does nothing if the scheme of the URI isn't cached.
+func (vm *VM) EvictResolverCache(uri string) error {
+	parts := strings.SplitN(uri, "://", 2)
+	if len(parts) != 2 {
+		return resolveError(uri, ErrMissingScheme)
+	}
+	if cached, ok := vm.resolvers[parts[0]].(*cache.CachedResolver); ok {
+		return cached.Evict(uri)
+	}
+	return nil
+}
+
+// PruneResolverCache removes the expired entries of the resolver
+// cache, and the content no longer referenced by any entry. It
+// does nothing if the VM has no resolver cache.
+func (vm *VM) PruneResolverCache() error {
+	for _, r := range vm.resolvers {
+		// the cached resolvers share the cache
+		// directory, so pruning one prunes all
+		if cached, ok := r.(*cache.CachedResolver); ok {
+			return cached.Prune()
+		}
+	}
+	return nil
 }
 
 func (vm *VM) LoadLens(l LensLoader) error {
+	if err := vm.ResolveLens(l); err != nil {
+		return err
+	}
+	if err := vm.compileLensImports(); err != nil {
+		return err
+	}
+	return vm.validateLensArguments()
+}
+
+// ResolveLens loads the lens like LoadLens, but only resolves its
+// import tree, without compiling the modules nor validating the
+// lens arguments, eg. to inspect its DependancyGraph or LockFile.
+func (vm *VM) ResolveLens(l LensLoader) error {
 	ctx := context.TODO()
 	lens, err := l.Load(ctx)
 	if err != nil {
@@ -153,19 +390,107 @@ func (vm *VM) LoadLens(l LensLoader) error {
 
 // Init initializes the virtual machine, assuming it as a loaded
 // lens file object. It creates the underlying WASM module
-// instances, and dynamically links all dependancies
+// instances, and dynamically links all dependancies. With a
+// PoolSize, the instances are only created by the pool.
 func (vm *VM) Init() error {
-	err := vm.makeDependancyGraph()
-	var root string
-	// grab any import path from our lens file
-	// note: range over a map has an undefined
-	// order, so effectively we get a random
-	// element from the map here.
+	// every imported lens is a root of the dependancy
+	// graph, sorted so modules are initialized in a
+	// deterministic order
+	var roots []string
+	seen := make(map[string]bool)
 	for _, mod := range vm.lensImports {
-		root = mod.id
-		break
+		if !seen[mod.id] {
+			seen[mod.id] = true
+			roots = append(roots, mod.id)
+		}
+	}
+	sort.Strings(roots)
+	if vm.poolSize > 0 {
+		return vm.initPool(roots...)
 	}
-	deps, err := vm.dgraph.SortOrder(root)
+	if err := vm.initDependancies(roots...); err != nil {
+		return err
+	}
+	vm.initialized = true
+	return nil
+}
+
+// initPool initializes the VM in pool mode, where Exec runs on
+// the pooled workers, so the VM doesn't instantiate the modules
+// itself. The first worker is created right away, so linking
+// errors are returned from Init.
+func (vm *VM) initPool(roots ...string) error {
+	if err := vm.makeDependancyGraph(); err != nil {
+		return err
+	}
+	deps, err := vm.dgraph.SortOrder(roots...)
+	if err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		mod, ok := vm.moduleImports[dep]
+		if !ok {
+			return &LinkError{Module: dep, Err: ErrMissingDependancy}
+		}
+		if err := mod.compile(); err != nil {
+			return err
+		}
+	}
+
+	if vm.pool.Load() == nil {
+		pool := newInstancePool(vm.poolSize, vm.newWorker)
+		w, err := pool.get(context.Background())
+		if err != nil {
+			return err
+		}
+		pool.put(w)
+		vm.pool.Store(pool)
+	}
+	vm.initialized = true
+	return nil
+}
+
+// Close closes all the module instances of the VM,
+// including the instances pooled for concurrent Exec
+// calls. The VM must be initialized again before use.
+func (vm *VM) Close() error {
+	if pool := vm.pool.Swap(nil); pool != nil {
+		pool.close()
+	}
+	vm.closeInstances()
+	vm.initialized = false
+	return nil
+}
+
+// closeInstances closes the module instances of the VM
+func (vm *VM) closeInstances() {
+	for _, mod := range vm.moduleImports {
+		mod.closeInstance()
+		mod.initialized = false
+	}
+}
+
+// closeInstance closes the instance of the module, if any,
+// and releases the memory it reserved from the budget
+func (mod *Module) closeInstance() {
+	if mod.winst != nil {
+		mod.winst.Close()
+	}
+	mod.winst = nil
+	mod.wmem = nil
+	mod.wfuel = nil
+	mod.scratchBase, mod.scratchSize, mod.scratchUsed = 0, 0, 0
+	mod.releaseMemory()
+}
+
+// initDependancies initializes the modules with the given IDs
+// and all of their dependancies, in dependancy order. Modules
+// that are already initialized are skipped.
+func (vm *VM) initDependancies(roots ...string) error {
+	if err := vm.makeDependancyGraph(); err != nil {
+		return err
+	}
+	deps, err := vm.dgraph.SortOrder(roots...)
 	if err != nil {
 		return err
 	}
@@ -173,7 +498,10 @@ func (vm *VM) Init() error {
 	for _, dep := range deps {
 		mod, ok := vm.moduleImports[dep]
 		if !ok {
-			return fmt.Errorf("Missing module dependancy: %s", dep)
+			return &LinkError{Module: dep, Err: ErrMissingDependancy}
+		}
+		if mod.initialized {
+			continue
 		}
 		if err := vm.moduleInit(mod); err != nil {
 			return err
@@ -187,75 +515,443 @@ func (vm *VM) Init() error {
 // depedant imports from both the VM host functions, and the dependancy
 // module functions.
 func (vm *VM) moduleInit(mod *Module) error {
-	importObj, err := vm.wasiEnv.GenerateImportObject(vm.wstore, mod.wmod)
-	if err != nil {
-		return nil
-	}
-
-	mod.importObject = importObj
-	if err := mod.RegisterFunc("env", "lensvm_get_buffer", vm.lensVMGetBufferBytes); err != nil {
-		return nil
+	if err := mod.compile(); err != nil {
+		return err
 	}
-	if err := mod.RegisterFunc("env", "lensvm_set_buffer", vm.lensVMSetBufferBytes); err != nil {
-		return nil
+	mod.imports = make(engine.Imports)
+	if err := vm.registerHostFuncs(mod); err != nil {
+		return err
 	}
 
 	// loop through the dependencies, and wire the exports/imports
 	for lens, m := range mod.dependancies {
-		// get the export from the dependancy
+		// only the lens functions the wasm module
+		// actually imports are linked
 		fnName := formatExecName(lens)
-		fn, err := m.winst.Exports.Get(fnName)
+		if !mod.funcImports["env"][fnName] {
+			continue
+		}
+
+		// get the export from the dependancy
+		fn, err := m.winst.Function(fnName)
 		if err != nil {
-			return err
+			return &LinkError{Module: mod.id, Import: lens, Err: err}
 		}
 
-		// add it to the importobject of the current module
-		mod.importObject.Register("env", map[string]wasmer.IntoExtern{
-			fnName: fn,
-		})
+		// add it to the imports of the current module
+		mod.imports.Register("env", fnName, m.forwardExec(fn))
 	}
 
-	// create new wasm instance
-	inst, err := wasmer.NewInstance(mod.wmod, mod.importObject)
+	// create new wasm instance, the engine runs the WASI
+	// entrypoint (if any) so the guest runtime can initialize
+	// itself, and register its lens functions
+	ctx, cancel := vm.limitContext(context.Background())
+	defer cancel()
+	inst, err := mod.wmod.Instantiate(ctx, mod.imports, vm.wasi)
 	if err != nil {
-		return err
+		mod.releaseMemory()
+		return vm.limitError(ctx, err)
 	}
 	mod.winst = inst
 
+	if vm.fuel > 0 {
+		fuel, err := inst.Global(engine.FuelGlobal)
+		if err != nil {
+			mod.closeInstance()
+			return err
+		}
+		mod.wfuel = fuel
+	}
+
+	mem, err := inst.Memory("memory")
+	if err != nil {
+		mod.closeInstance()
+		return err
+	}
+	mod.wmem = mem
+
+	if err := mod.reserveMemory(); err != nil {
+		mod.closeInstance()
+		return err
+	}
+	mod.initialized = true
+
 	return nil
 }
 
 func formatExecName(name string) string {
-	return fmt.Sprintf("lensvm_%s_exec", name)
+	return fmt.Sprintf("lensvm_exec_%s", name)
 }
 
 // Exec does the actual lens execution and transformation
 // of the input, producing some output. It will execute all
 // the lenses in the LensFile, incrementally merging the
-// individual outputs, until it completes all lenses.
+// individual outputs, until it completes all lenses. Every
+// lens outputs a JSON Merge Patch, which is applied to the
+// document before it is passed to the next lens.
 func (vm *VM) Exec(input []byte) (out []byte, err error) {
-	return
+	return vm.ExecContext(context.Background(), input)
+}
+
+// ExecContext is like Exec, and if the VM has an instance pool,
+// it runs on a pooled instance, so it's safe to call concurrently.
+// The context bounds how long it waits for a free instance, and
+// the exec itself. If the engine can't interrupt calls, eg. wasmer,
+// a context which can be done requires Fuel, like ExecTimeout, or
+// it fails with ErrTimeoutNeedsFuel.
+func (vm *VM) ExecContext(ctx context.Context, input []byte) ([]byte, error) {
+	if vm.poolSize == 0 {
+		if !vm.initialized {
+			return nil, ErrInstanceNotStart
+		}
+		if err := vm.checkInterrupt(ctx); err != nil {
+			return nil, err
+		}
+		out, err := vm.exec(ctx, input)
+		if isInterrupted(err) {
+			vm.recoverInstances()
+		}
+		return out, err
+	}
+
+	// the pool is loaded once, as Close may be called
+	// meanwhile, after which the pool is closed
+	pool := vm.pool.Load()
+	if pool == nil {
+		return nil, ErrInstanceNotStart
+	}
+	if err := vm.checkInterrupt(ctx); err != nil {
+		return nil, err
+	}
+	w, err := pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := w.exec(ctx, input)
+	if isInterrupted(err) {
+		pool.discard(w)
+		return nil, err
+	}
+	pool.put(w)
+	return out, err
+}
+
+// exec runs all the lenses in the LensFile on the module
+// instances of the VM, within the limits of the VM
+func (vm *VM) exec(ctx context.Context, input []byte) (out []byte, err error) {
+	ctx, cancel := vm.limitContext(ctx)
+	defer cancel()
+
+	vm.resetBuffers()
+	fuel := vm.fuel
+	doc := input
+	for i, lens := range vm.lensFile.Lenses {
+		name, args, err := lensEntry(lens)
+		if err != nil {
+			return nil, &ExecError{Index: i, Err: err}
+		}
+
+		mod, ok := vm.lensImports[name]
+		if !ok {
+			return nil, &ExecError{Lens: name, Index: i, Err: ErrLensNotImported}
+		}
+
+		var patch []byte
+		patch, fuel, err = mod.execLimited(ctx, name, args, doc, fuel)
+		if err != nil {
+			return nil, vm.execError(name, i, err)
+		}
+		doc, err = mergePatch(doc, patch)
+		if err != nil {
+			return nil, vm.execError(name, i, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// ExecFunc executes a single lens function exported by the module
+// at the given path, with the given input and arguments, and returns
+// the input with the patch of the lens applied. If the module hasn't
+// been imported yet, it is imported and initialized along with its
+// dependancies.
+func (vm *VM) ExecFunc(input, args []byte, lensName, modulePath string) ([]byte, error) {
+	mod, ok := vm.moduleImports[modulePath]
+	if !ok {
+		var err error
+		mod, err = vm.ImportModuleFunction(lensName, modulePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !moduleHasLensFunc(mod.definition, lensName) {
+		return nil, &LinkError{Module: mod.id, Import: lensName, Err: ErrMissingLensFunc}
+	}
+
+	if !mod.initialized {
+		if err := vm.initDependancies(mod.id); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := vm.limitContext(context.Background())
+	defer cancel()
+	patch, _, err := mod.execLimited(ctx, lensName, args, input, vm.fuel)
+	if err == nil {
+		var out []byte
+		out, err = mergePatch(input, patch)
+		if err == nil {
+			return out, nil
+		}
+	}
+
+	err = vm.execError(lensName, -1, err)
+	if isInterrupted(err) {
+		vm.recoverInstances()
+	}
+	return nil, err
+}
+
+// exec runs the named lens function exported by the module
+// with the given arguments and input document, and returns
+// the JSON Merge Patch written by the lens.
+func (mod *Module) exec(ctx context.Context, name string, args, input []byte) ([]byte, error) {
+	if !mod.initialized {
+		return nil, ErrInstanceNotStart
+	}
+
+	fn, err := mod.winst.Function(formatExecName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := mod.callExec(ctx, fn, 0, 1, args, input)
+	if err != nil {
+		return nil, err
+	}
+	if status != int32(stypes.StatusOK) {
+		if err := mod.vm.hostErr; err != nil {
+			return nil, fmt.Errorf("%w: lens failed with status %d", err, status)
+		}
+		return nil, fmt.Errorf("%w with status %d", ErrLensFailed, status)
+	}
+	return mod.vm.GetBuffer(stypes.BufferTypeOutputPatch), nil
+}
+
+// callExec calls the exec function of a lens in its calling
+// convention, see abi.go, and returns the status of the lens.
+// The lens writes its patch into the output patch buffer.
+func (mod *Module) callExec(ctx context.Context, fn engine.Function, contextID, forward int32, args, input []byte) (int32, error) {
+	vm := mod.vm
+	if err := vm.SetBuffer(stypes.BufferTypeInputArg, args); err != nil {
+		return 0, err
+	}
+	if err := vm.SetBuffer(stypes.BufferTypeInputData, input); err != nil {
+		return 0, err
+	}
+	if err := vm.SetBuffer(stypes.BufferTypeOutputPatch, nil); err != nil {
+		return 0, err
+	}
+
+	var params []interface{}
+	var copies []int32
+	switch len(fn.Params()) {
+	case 5:
+		params = []interface{}{forward, int32(0), int32(len(args)), int32(0), int32(len(input))}
+	case 6:
+		argsPtr, err := mod.copyToGuest(ctx, args)
+		if err != nil {
+			return 0, err
+		}
+		inputPtr, err := mod.copyToGuest(ctx, input)
+		if err != nil {
+			mod.free(ctx, argsPtr)
+			return 0, err
+		}
+		copies = []int32{argsPtr, inputPtr}
+		params = []interface{}{contextID, forward, argsPtr, int32(len(args)), inputPtr, int32(len(input))}
+	default:
+		return 0, fmt.Errorf("%w: %d params", ErrExecSignature, len(fn.Params()))
+	}
+
+	res, err := fn.Call(ctx, params...)
+	if err != nil {
+		// the guest may still use its memory, if the
+		// call was abandoned, so the copies are leaked
+		return 0, err
+	}
+	if err := mod.free(ctx, copies...); err != nil {
+		return 0, err
+	}
+	if len(res) != 1 {
+		return 0, fmt.Errorf("%w: %d results, expected a status", ErrExecSignature, len(res))
+	}
+	status, ok := res[0].(int32)
+	if !ok {
+		return 0, fmt.Errorf("%w: status %v isn't an i32", ErrExecSignature, res[0])
+	}
+	return status, nil
+}
+
+// forwardExec returns the host function linking the exec function
+// of a lens of the module, into the modules importing it. The calling
+// lens passes its args and input in the temp buffers, or in its memory,
+// and gets the patch of the lens in the temp input data buffer. The
+// buffers of the calling lens are kept as they are.
+func (mod *Module) forwardExec(fn engine.Function) engine.HostFunction {
+	return engine.HostFunction{
+		Params:  fn.Params(),
+		Results: fn.Results(),
+		Func: func(ctx context.Context, params []interface{}) ([]interface{}, error) {
+			vm := mod.vm
+			contextID, forward, args, input, err := vm.forwardedArgs(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+
+			saved := make(map[stypes.BufferType][]byte, 3)
+			for _, bt := range []stypes.BufferType{stypes.BufferTypeInputData, stypes.BufferTypeInputArg, stypes.BufferTypeOutputPatch} {
+				saved[bt] = vm.buffers[bt]
+			}
+			status, err := mod.callExec(ctx, fn, contextID, forward, args, input)
+			patch := vm.buffers[stypes.BufferTypeOutputPatch]
+			for bt, buf := range saved {
+				vm.buffers[bt] = buf
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			vm.buffers[stypes.BufferTypeTempInputData] = patch
+			return []interface{}{status}, nil
+		},
+	}
+}
+
+// forwardedArgs returns the args and input a lens passes to the
+// exec function of another lens, in the calling convention of
+// the exec function, see abi.go
+func (vm *VM) forwardedArgs(ctx context.Context, params []interface{}) (contextID, forward int32, args, input []byte, err error) {
+	p := make([]int32, len(params))
+	for i, param := range params {
+		v, ok := param.(int32)
+		if !ok {
+			return 0, 0, nil, nil, fmt.Errorf("%w: param %d isn't an i32", ErrExecSignature, i)
+		}
+		p[i] = v
+	}
+
+	switch len(p) {
+	case 5:
+		args, err = sliceBuffer(vm.buffers[stypes.BufferTypeTempInputArg], p[1], p[2])
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		input, err = sliceBuffer(vm.buffers[stypes.BufferTypeTempInputData], p[3], p[4])
+		return 0, p[0], args, input, err
+	case 6:
+		mem, ok := engine.Caller(ctx)
+		if !ok {
+			return 0, 0, nil, nil, ErrInstanceNotStart
+		}
+		args, err = readMemory(mem.Data(), p[2], p[3])
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		input, err = readMemory(mem.Data(), p[4], p[5])
+		return p[0], p[1], args, input, err
+	}
+	return 0, 0, nil, nil, fmt.Errorf("%w: %d params", ErrExecSignature, len(p))
+}
+
+// lensEntry unpacks a single entry of the LensFile lenses
+// array, which must contain exactly one lens name mapped
+// to its arguments.
+func lensEntry(lens map[string]*json.RawMessage) (string, []byte, error) {
+	if len(lens) != 1 {
+		return "", nil, fmt.Errorf("%w, got %d", ErrInvalidLensEntry, len(lens))
+	}
+
+	for name, args := range lens {
+		if args == nil {
+			return name, nil, nil
+		}
+		return name, []byte(*args), nil
+	}
+	return "", nil, nil
 }
 
 // func (vm *VM) ResolverContext()
 
 func (vm *VM) resolveLens(ctx context.Context, lens types.LensFile) error {
 	foundModules := make(map[string]bool)
-	for name, modPath := range lens.Import {
-		resolvedMod, err, ok := vm.resolveModule(ctx, foundModules, modPath)
+	names, claimed := claimImports(foundModules, lens.Import)
+	for _, name := range names {
+		if !claimed[name] {
+			continue
+		}
+
+		resolvedMod, err := vm.resolveModuleFile(ctx, foundModules, lens.Import[name])
 		if err != nil {
 			return err
 		}
+		if _, err, _ := vm.addGlobalImport(name, resolvedMod); err != nil {
+			return err
+		}
+	}
 
-		if !ok {
+	// imports of modules which are already imported,
+	// eg. another lens function of the same module
+	for _, name := range names {
+		if claimed[name] {
 			continue
 		}
+		if mod, ok := vm.moduleImports[lens.Import[name].URI]; ok {
+			if _, err, _ := vm.addGlobalImport(name, mod.definition); err != nil {
+				return err
+			}
+		}
+	}
 
-		if _, err, _ := vm.addGlobalImport(name, resolvedMod); err != nil {
-			return err
+	if err := vm.resolveVersions(lens); err != nil {
+		return err
+	}
+
+	// imports of modules which are already resolved are
+	// left empty, so cycles are only found in the graph
+	return vm.checkDependancyCycles()
+}
+
+// compileLensImports compiles the modules linked to the lens
+// imports, and the modules they import. Superseded modules,
+// see resolveVersions, are never compiled.
+func (vm *VM) compileLensImports() error {
+	names := sortedKeys(vm.lensImports)
+	mods := make([]*Module, len(names))
+	for i, name := range names {
+		mods[i] = vm.lensImports[name]
+	}
+	return vm.compileModules(mods...)
+}
+
+// claimImports returns the sorted names of the given imports, and
+// which of them claimed the module they import, since it wasn't
+// found yet. Claiming all the imports before resolving any of them
+// resolves every module under its shallowest importer, in a
+// deterministic order.
+func claimImports(foundModules map[string]bool, imports map[string]types.Reference) ([]string, map[string]bool) {
+	names := sortedKeys(imports)
+
+	claimed := make(map[string]bool)
+	for _, name := range names {
+		path := imports[name].URI
+		if _, ok := foundModules[path]; !ok {
+			foundModules[path] = true
+			claimed[name] = true
 		}
 	}
-	return nil
+	return names, claimed
 }
 
 /*
@@ -275,7 +971,10 @@ func (vm *VM) ImportModule(path string) (*Module, error) {
 	}
 
 	mod, err, _ := vm.addGlobalImport("*", rmod)
-	return mod, err
+	if err != nil {
+		return mod, err
+	}
+	return mod, vm.compileModules(mod)
 }
 
 // ImportModuleFunction will resolve the module from the
@@ -297,7 +996,10 @@ func (vm *VM) ImportModuleFunction(name, path string) (*Module, error) {
 	}
 
 	mod, err, _ := vm.addGlobalImport(name, rmod)
-	return mod, err
+	if err != nil {
+		return mod, err
+	}
+	return mod, vm.compileModules(mod)
 }
 
 // func (vm) ResolveContext()
@@ -313,23 +1015,23 @@ func (vm *VM) addGlobalImport(name string, rmod types.ResolvedModule) (*Module,
 // Recursively add all the necessary depenencies.
 func (vm *VM) addScopedImport(scope importSetter, name string, rmod types.ResolvedModule) (*Module, error, bool) {
 	if len(name) == 0 {
-		return nil, errors.New("Missing name of import function"), false
+		return nil, &LinkError{Module: rmod.ID, Err: ErrMissingImportName}, false
 	}
 	if len(rmod.ID) == 0 {
-		fmt.Println(rmod)
-		return nil, errors.New("Invalid resolved module object. Missing ID"), false
+		return nil, &LinkError{Import: name, Err: ErrMissingModuleID}, false
 	}
 	if mod, exists := vm.moduleImports[rmod.ID]; exists {
 		if name == "*" {
 			for _, export := range rmod.Exports {
 				scope.setLensImport(export.Name, mod)
 			}
+			return mod, nil, false
 		} else {
 			if moduleHasLensFunc(rmod, name) {
 				scope.setLensImport(name, mod)
 				return mod, nil, false
 			}
-			return nil, fmt.Errorf("Lens function '%s' is missing from module", name), false
+			return nil, &LinkError{Module: rmod.ID, Import: name, Err: ErrMissingLensFunc}, false
 		}
 	}
 
@@ -342,8 +1044,10 @@ func (vm *VM) addScopedImport(scope importSetter, name string, rmod types.Resolv
 	scope.setLensImport(name, mod)
 
 	// loop and add all the modules' dependencies on this scope
-	// recursively
-	for k, v := range rmod.Imports {
+	// recursively, in order. Imports of modules resolved by another
+	// importer are empty, and linked by makeDependancyGraph.
+	for _, k := range sortedKeys(rmod.Imports) {
+		v := rmod.Imports[k]
 		// check if empty
 		if reflect.DeepEqual(types.ResolvedModule{}, v.Module) {
 			continue
@@ -364,30 +1068,93 @@ func (vm *VM) addScopedImport(scope importSetter, name string, rmod types.Resolv
 func (vm *VM) newModule(rmod types.ResolvedModule) (*Module, error) {
 	// check ID and PackageBytes
 	if rmod.ID == "" {
-		return nil, errors.New("Invalid resolved module object. Missing ID")
+		return nil, &LinkError{Err: ErrMissingModuleID}
 	}
 	if len(rmod.PackageBytes) == 0 {
-		return nil, errors.New("Missing module wasm bytes")
+		return nil, resolveError(rmod.ID, ErrMissingPackage)
 	}
 	exports := make(map[string]*json.RawMessage)
 	for _, e := range rmod.Exports {
 		exports[e.Name] = e.Arguments
 	}
 
-	wmod, err := wasmer.NewModule(vm.wstore, rmod.PackageBytes)
-	if err != nil {
-		return nil, err
-	}
 	return &Module{
 		vm:           vm,
 		id:           rmod.ID,
 		definition:   rmod,
 		dependancies: make(map[string]*Module),
 		exportArgs:   exports,
-		wmod:         wmod,
+		argSchemas:   make(map[string]*gojsonschema.Schema),
 	}, nil
 }
 
+// compile instruments the wasm package of the module with the
+// limits of the VM, and compiles it. Modules are compiled once
+// their imports are linked, so the ones nothing links to are
+// never compiled.
+func (mod *Module) compile() error {
+	if mod.wmod != nil {
+		return nil
+	}
+	vm := mod.vm
+	rmod := mod.definition
+
+	wasm := rmod.PackageBytes
+	if vm.maxMemoryPages > 0 {
+		var err error
+		wasm, err = engine.LimitMemory(wasm, vm.maxMemoryPages)
+		if err != nil {
+			return &LinkError{Module: rmod.ID, Err: fmt.Errorf("Failed to limit memory: %w", err)}
+		}
+	}
+	if vm.maxMemoryPages > 0 || vm.budget != nil {
+		var err error
+		wasm, err = engine.HookMemoryGrow(wasm)
+		if err != nil {
+			return &LinkError{Module: rmod.ID, Err: fmt.Errorf("Failed to hook memory growth: %w", err)}
+		}
+	}
+	if vm.fuel > 0 {
+		var err error
+		wasm, err = engine.Meter(wasm, vm.fuel)
+		if err != nil {
+			return &LinkError{Module: rmod.ID, Err: fmt.Errorf("Failed to meter: %w", err)}
+		}
+	}
+
+	funcImports, err := engine.ImportedFuncs(wasm)
+	if err != nil {
+		return &LinkError{Module: rmod.ID, Err: err}
+	}
+	wmod, err := vm.engine.Compile(wasm)
+	if err != nil {
+		return &LinkError{Module: rmod.ID, Err: err}
+	}
+	mod.funcImports = funcImports
+	mod.wmod = wmod
+	return nil
+}
+
+// compileModules compiles the given modules, and all of
+// the modules they link to, which aren't compiled yet
+func (vm *VM) compileModules(mods ...*Module) error {
+	for _, mod := range mods {
+		if mod.wmod != nil {
+			continue
+		}
+		if err := mod.compile(); err != nil {
+			return err
+		}
+
+		for _, name := range sortedKeys(mod.dependancies) {
+			if err := vm.compileModules(mod.dependancies[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (vm *VM) ResolveModule(path string) (types.ResolvedModule, error) {
 	foundModules := make(map[string]bool)
 	// preload the found map with our current imports
@@ -395,15 +1162,17 @@ func (vm *VM) ResolveModule(path string) (types.ResolvedModule, error) {
 		foundModules[k] = true
 	}
 	ctx := context.TODO()
-	mod, err, _ := vm.resolveModule(ctx, foundModules, path)
+	mod, err, _ := vm.resolveModule(ctx, foundModules, types.Reference{URI: path})
 	return mod, err
 }
 
-// resolveModule takes a path and map of previous resolved modules
-// and returns the module along with its imports resolved.
+// resolveModule takes a module reference and map of previous resolved
+// modules and returns the module along with its imports resolved.
 // If it imports an already resolved module, that import will
-// contain an empty ResolvedModule type.
-func (vm *VM) resolveModule(ctx context.Context, foundModules map[string]bool, path string) (types.ResolvedModule, error, bool) {
+// contain an empty ResolvedModule type. The module file and package
+// are verified against the reference and package integrity, if any.
+func (vm *VM) resolveModule(ctx context.Context, foundModules map[string]bool, ref types.Reference) (types.ResolvedModule, error, bool) {
+	path := ref.URI
 	if foundModules == nil {
 		foundModules = make(map[string]bool)
 	}
@@ -412,20 +1181,51 @@ func (vm *VM) resolveModule(ctx context.Context, foundModules map[string]bool, p
 	}
 	foundModules[path] = true
 
-	buf, err := vm.resolve(ctx, path)
+	mod, err := vm.resolveModuleFile(ctx, foundModules, ref)
 	if err != nil {
 		return types.ResolvedModule{}, err, false
 	}
+	return mod, nil, true
+}
 
-	var modFile types.ModuleFile
-	err = json.Unmarshal(buf, &modFile)
+// resolveModuleFile resolves the referenced module file, which
+// must already be in the found modules, and its imports.
+func (vm *VM) resolveModuleFile(ctx context.Context, foundModules map[string]bool, ref types.Reference) (types.ResolvedModule, error) {
+	path := ref.URI
+
+	// when loading from a lock file, the module file is loaded
+	// from its locked concrete URI, and must match the locked hash
+	locked, isLocked, err := vm.lockedModule(path)
 	if err != nil {
-		return types.ResolvedModule{}, err, false
+		return types.ResolvedModule{}, resolveError(path, err)
+	}
+	from := path
+	if isLocked && locked.Resolved != "" {
+		from = locked.Resolved
+	}
+
+	buf, resolvedPath, err := vm.resolve(ctx, from, ref.Version)
+	if err != nil {
+		return types.ResolvedModule{}, err
+	}
+	if err := verifyIntegrity(path, ref.Integrity, buf); err != nil {
+		return types.ResolvedModule{}, resolveError(path, err)
+	}
+	if isLocked {
+		if err := verifyIntegrity(path, locked.Integrity, buf); err != nil {
+			return types.ResolvedModule{}, resolveError(path, err)
+		}
 	}
 
 	//validate ModuleFile
-	if len(modFile.Exports) == 0 {
-		return types.ResolvedModule{}, fmt.Errorf("Resolved module at path %s does not export any lens functions", path), false
+	if err := ValidateModuleFile(buf); err != nil {
+		return types.ResolvedModule{}, resolveError(path, err)
+	}
+
+	var modFile types.ModuleFile
+	err = json.Unmarshal(buf, &modFile)
+	if err != nil {
+		return types.ResolvedModule{}, resolveError(path, err)
 	}
 
 	// flatten the original modFile into a array.
@@ -434,48 +1234,90 @@ func (vm *VM) resolveModule(ctx context.Context, foundModules map[string]bool, p
 	// modFile
 	root := types.ModuleToResolvedModule(modFile)
 	root.ID = path
+	root.Integrity = Integrity(buf)
+	root.ResolvedPath = resolvedPath
+
+	names, claimed := claimImports(foundModules, modFile.Import)
+	for _, n := range names {
+		p := modFile.Import[n]
+		if isLocked {
+			id, ok := locked.Imports[n]
+			if !ok || id != p.URI {
+				return types.ResolvedModule{}, resolveError(path, fmt.Errorf("%w: import '%s' of module %s is not locked to %s", ErrLockFileOutdated, n, path, p.URI))
+			}
+		}
 
-	for n, p := range modFile.Import {
-		// impModule := types.ImportedModule{Path: p}
-		mod, err, _ := vm.resolveModule(ctx, foundModules, p)
-		if err != nil {
-			return types.ResolvedModule{}, err, false
+		// modules claimed by another importer are left empty
+		var mod types.ResolvedModule
+		if claimed[n] {
+			mod, err = vm.resolveModuleFile(ctx, foundModules, p)
+			if err != nil {
+				return types.ResolvedModule{}, err
+			}
 		}
 		root.Imports[n] = types.ImportedModule{
-			Path:   p,
-			Module: mod,
+			Path:      p.URI,
+			Integrity: p.Integrity,
+			Version:   p.Version,
+			Module:    mod,
+		}
+	}
+
+	pkgFrom := modFile.Package.URI
+	if isLocked {
+		if locked.Package.URI != modFile.Package.URI {
+			return types.ResolvedModule{}, resolveError(path, fmt.Errorf("%w: package of module %s is not locked to %s", ErrLockFileOutdated, path, modFile.Package.URI))
+		}
+		if locked.PackageResolved != "" {
+			pkgFrom = locked.PackageResolved
 		}
 	}
 
-	wasmBytes, err := vm.resolve(ctx, modFile.Package)
+	wasmBytes, pkgResolvedPath, err := vm.resolve(ctx, pkgFrom, "")
 	if err != nil {
-		return types.ResolvedModule{}, err, false
+		return types.ResolvedModule{}, err
+	}
+	if err := verifyIntegrity(modFile.Package.URI, modFile.Package.Integrity, wasmBytes); err != nil {
+		return types.ResolvedModule{}, resolveError(modFile.Package.URI, err)
+	}
+	if isLocked {
+		if err := verifyIntegrity(locked.Package.URI, locked.Package.Integrity, wasmBytes); err != nil {
+			return types.ResolvedModule{}, resolveError(locked.Package.URI, err)
+		}
 	}
 	root.PackageBytes = wasmBytes
+	root.PackageResolvedPath = pkgResolvedPath
 	root.ID = path
 
-	return root, nil, true
+	return root, nil
 	// return types.ResolvedModule{
 	// 	ID:      path,
 	// 	Modules: rMods,
 	// }, nil, true
 }
 
-func (vm *VM) resolve(ctx context.Context, path string) ([]byte, error) {
+// resolve resolves the path within the semver range, if any, and
+// returns the concrete URI it was resolved from, see
+// resolvers.ConcreteResolver and resolvers.VersionResolver
+func (vm *VM) resolve(ctx context.Context, path, rng string) ([]byte, string, error) {
 	if !strings.Contains(path, "://") {
-		return nil, errors.New("resolve path is missing a URI scheme")
+		return nil, "", resolveError(path, ErrMissingScheme)
 	}
 	parts := strings.Split(path, "://")
 	if len(parts) != 2 {
-		return nil, errors.New("Malformed resolve path, must be of the form '<scheme>://<path>'")
+		return nil, "", resolveError(path, ErrMalformedPath)
 	}
 
 	resolver, ok := vm.resolvers[parts[0]]
 	if !ok {
-		return nil, fmt.Errorf("No resolver for given scheme %s", parts[0])
+		return nil, "", resolveError(path, fmt.Errorf("%w %s", ErrNoResolver, parts[0]))
 	}
 
-	return resolver.Resolve(ctx, parts[1])
+	buf, concrete, err := resolvers.ResolveVersion(ctx, resolver, parts[1], rng)
+	if err != nil {
+		return nil, "", resolveError(path, err)
+	}
+	return buf, parts[0] + "://" + strings.TrimPrefix(concrete, parts[0]+"://"), nil
 }
 
 func (vm *VM) setModuleImport(name string, target *Module) {
@@ -486,10 +1328,14 @@ func (vm *VM) setLensImport(name string, target *Module) {
 	vm.lensImports[name] = target
 }
 
-func hash256(buf []byte) string {
-	h := sha256.New()
-	h.Write(buf)
-	return string(h.Sum(nil))
+// sortedKeys returns the keys of the map in sorted order
+func sortedKeys[M ~map[string]V, V any](m M) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func moduleHasLensFunc(rmod types.ResolvedModule, name string) bool {
@@ -1,9 +1,19 @@
 package lensvm
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"testing"
 
+	"github.com/lens-vm/lens-vm-go-host/engine/wazero"
+	"github.com/lens-vm/lens-vm-go-host/resolvers"
+	"github.com/lens-vm/lens-vm-go-host/resolvers/file"
+	stypes "github.com/lens-vm/lens-vm-go-sdk/types"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestVMInitSimpleLens(t *testing.T) {
@@ -26,3 +36,230 @@ func TestVMInitSimpleLens(t *testing.T) {
 		assert.NotNil(t, v.winst, k)
 	}
 }
+
+func TestVMInitSimpleLensWazero(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		Engine:    wazero.New(),
+	})
+	assert.NotNil(t, vm)
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+
+	err = vm.Init()
+	assert.NoError(t, err)
+
+	for k, v := range vm.moduleImports {
+		assert.NotNil(t, v.winst, k)
+		assert.NotNil(t, v.wmem, k)
+	}
+}
+
+func TestVMInitMultiRoot(t *testing.T) {
+	vm := NewVM(nil)
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/multiroot/lens.json"))
+	require.NoError(t, err)
+
+	err = vm.Init()
+	require.NoError(t, err)
+
+	// the simple module is both a root, and a dependancy of
+	// the extract root, and is only instantiated once
+	require.Len(t, vm.moduleImports, 3)
+	for k, v := range vm.moduleImports {
+		assert.True(t, v.initialized, k)
+		assert.NotNil(t, v.winst, k)
+	}
+	simple := vm.moduleImports["file://testdata/simple/module.json"]
+	extract := vm.moduleImports["file://testdata/importsimple/module.json"]
+	require.NotNil(t, simple)
+	require.NotNil(t, extract)
+	assert.Same(t, simple, extract.dependancies["rename"])
+	assert.Same(t, simple, vm.lensImports["rename"])
+}
+
+func TestVMInitWASIDisabled(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		Engine:    wazero.New(),
+		WASI:      &WASIOptions{Disabled: true},
+	})
+	assert.Nil(t, vm.wasi)
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+
+	// the simple module imports the WASI functions
+	err = vm.Init()
+	assert.Error(t, err)
+}
+
+func TestVMWASIOptions(t *testing.T) {
+	vm := NewVM(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		Engine:    wazero.New(),
+		WASI: &WASIOptions{
+			Args:         []string{"-v"},
+			Env:          map[string]string{"LENS": "simple"},
+			ReadOnlyDirs: map[string]string{"/testdata": "testdata"},
+		},
+	})
+	assert.Equal(t, []string{"-v"}, vm.wasi.Args)
+	assert.Equal(t, "simple", vm.wasi.Env["LENS"])
+	assert.Equal(t, "testdata", vm.wasi.Dirs["/testdata"])
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+	err = vm.Init()
+	assert.NoError(t, err)
+
+	// the engine must support read-only directories
+	_, err = NewVMWithError(&Options{
+		Resolvers: DefaultOptions.Resolvers,
+		Engine:    featurelessEngine{wazero.New()},
+		WASI: &WASIOptions{
+			ReadOnlyDirs: map[string]string{"/testdata": "testdata"},
+		},
+	})
+	var optErr *OptionsError
+	assert.True(t, errors.As(err, &optErr), err)
+	assert.True(t, errors.Is(err, ErrUnsupported), err)
+}
+
+type schemeResolver string
+
+func (r schemeResolver) Scheme() string {
+	return string(r)
+}
+
+func (r schemeResolver) Resolve(ctx context.Context, path string) ([]byte, error) {
+	return nil, nil
+}
+
+func TestNewVMWithError(t *testing.T) {
+	vm, err := NewVMWithError(nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, vm)
+
+	_, err = NewVMWithError(&Options{
+		Resolvers: []resolvers.Resolver{schemeResolver("")},
+	})
+	assert.True(t, errors.Is(err, ErrEmptyResolverScheme))
+
+	_, err = NewVMWithError(&Options{
+		Resolvers: []resolvers.Resolver{file.FileResolver{}, schemeResolver("file")},
+	})
+	assert.True(t, errors.Is(err, ErrDuplicateResolverScheme))
+	var optErr *OptionsError
+	assert.True(t, errors.As(err, &optErr))
+	assert.Equal(t, "Resolvers", optErr.Option)
+
+	_, err = NewVMWithError(&Options{
+		Resolvers: []resolvers.Resolver{nil},
+	})
+	assert.True(t, errors.Is(err, ErrNilResolver))
+
+	_, err = NewVMWithError(&Options{PoolSize: -1})
+	assert.True(t, errors.Is(err, ErrNegativeOption))
+
+	_, err = NewVMWithError(&Options{Engine: wazero.New(), ModuleCacheDir: t.TempDir()})
+	assert.True(t, errors.Is(err, ErrModuleCacheWithEngine))
+}
+
+func TestVMModuleCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		vm, err := NewVMWithError(&Options{
+			Resolvers:      DefaultOptions.Resolvers,
+			ModuleCacheDir: dir,
+		})
+		require.NoError(t, err)
+		require.NoError(t, vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json")))
+		require.NoError(t, vm.Init())
+		out, err := vm.Exec([]byte(`{"body":"hello"}`))
+		require.NoError(t, err)
+		assert.Contains(t, string(out), `"description":"hello"`)
+	}
+
+	// the default engine cached the compiled module
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, files)
+}
+
+func TestNewVMPanics(t *testing.T) {
+	assert.Panics(t, func() {
+		NewVM(&Options{
+			Resolvers: []resolvers.Resolver{schemeResolver("")},
+		})
+	})
+}
+
+func TestVMExecBeforeInit(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+
+	_, err = vm.Exec([]byte(`{"body": "hello"}`))
+	assert.Equal(t, ErrInstanceNotStart, err)
+}
+
+func TestVMExecSimpleLens(t *testing.T) {
+	vm := NewVM(nil)
+	assert.NotNil(t, vm)
+
+	err := vm.LoadLens(LensFileLoader("file://testdata/lens/simple/lens.json"))
+	assert.NoError(t, err)
+	err = vm.Init()
+	assert.NoError(t, err)
+
+	// the rename lens patches the document with the destination
+	out, err := vm.Exec([]byte(`{"body": "hello", "title": "lens"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"body": "hello", "title": "lens", "description": "hello"}`, string(out))
+
+	out, err = vm.ExecFunc([]byte(`{"body": "bye"}`), []byte(`{"source": "body", "destination": "title"}`), "rename", "file://testdata/simple/module.json")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"body": "bye", "title": "bye"}`, string(out))
+}
+
+func TestForwardExec(t *testing.T) {
+	vm := NewVM(&Options{Engine: wazero.New()})
+	mod := instantiateModule(t, vm, "testdata/simple/main.wasm")
+	defer mod.winst.Close()
+
+	fn, err := mod.winst.Function(formatExecName("rename"))
+	assert.NoError(t, err)
+	forward := mod.forwardExec(fn)
+	assert.Equal(t, fn.Params(), forward.Params)
+
+	// the calling lens passes the args and input in the temp buffers
+	args := []byte(`{"source": "body", "destination": "description"}`)
+	input := []byte(`{"body": "hello"}`)
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeInputData, []byte("caller input")))
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeTempInputArg, args))
+	assert.NoError(t, vm.SetBuffer(stypes.BufferTypeTempInputData, input))
+
+	res, err := forward.Func(context.Background(), []interface{}{int32(1), int32(0), int32(len(args)), int32(0), int32(len(input))})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(stypes.StatusOK)}, res)
+	assert.JSONEq(t, `{"description": "hello"}`, string(vm.GetBuffer(stypes.BufferTypeTempInputData)))
+	assert.Equal(t, "caller input", string(vm.GetBuffer(stypes.BufferTypeInputData)))
+
+	_, err = forward.Func(context.Background(), []interface{}{int32(1), int32(0), int32(len(args) + 1), int32(0), int32(0)})
+	assert.Equal(t, ErrAddrOverflow, err)
+}
+
+func TestLensEntry(t *testing.T) {
+	args := json.RawMessage(`{"source": "body"}`)
+	name, buf, err := lensEntry(map[string]*json.RawMessage{"rename": &args})
+	assert.NoError(t, err)
+	assert.Equal(t, "rename", name)
+	assert.Equal(t, []byte(args), buf)
+
+	_, _, err = lensEntry(map[string]*json.RawMessage{"rename": &args, "copy": &args})
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package lensvm
+
+import (
+	"io"
+
+	"github.com/lens-vm/lens-vm-go-host/engine"
+)
+
+// WASIOptions configures the WASI environment
+// of the module instances of a VM.
+type WASIOptions struct {
+	// Disabled disables WASI, so modules can't import
+	// any of the WASI functions.
+	Disabled bool
+
+	// Args are the command line arguments
+	// of the modules, after the program name
+	Args []string
+
+	// Env are the environment variables
+	// of the modules
+	Env map[string]string
+
+	// ReadOnlyDirs maps guest paths to host directories,
+	// which are preopened read-only for the modules. It
+	// isn't supported by the wasmer engine, so NewVM
+	// fails if it's set with an unsupporting engine.
+	ReadOnlyDirs map[string]string
+
+	// Stdout and Stderr if set, receive the output
+	// of the modules, otherwise it's discarded. With
+	// an instance pool, they must be safe for
+	// concurrent use.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// engineWASI returns the engine WASI environment
+// of the options, which is nil if WASI is disabled.
+// Nil options are the default environment, with no
+// arguments, variables or directories.
+func (o *WASIOptions) engineWASI() *engine.WASI {
+	if o == nil {
+		return &engine.WASI{}
+	}
+	if o.Disabled {
+		return nil
+	}
+	return &engine.WASI{
+		Args:   o.Args,
+		Env:    o.Env,
+		Dirs:   o.ReadOnlyDirs,
+		Stdout: o.Stdout,
+		Stderr: o.Stderr,
+	}
+}